@@ -0,0 +1,86 @@
+package prompt
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Args are the arguments a command was called with.  Placeholder arguments
+// can be fetched by name, e.g. "host" for $host, or number, e.g. "1" for $1.
+type Args struct {
+	values    map[string]string      // placeholder arguments, keyed by name
+	converted map[string]interface{} // placeholder arguments converted by their type
+	rest      []string               // the words matched by $*
+	options   map[string]string      // keyword-value options given, by keyword
+	list      []string               // all of the arguments, as passed to an ErrCommand
+	flags     *flagSet               // the flags given, and defaults
+	ctx       context.Context        // the context the command is run with
+}
+
+// Get returns the argument for a placeholder, or an empty string if it
+// wasn't given.
+func (a *Args) Get(name string) string {
+	return a.values[strings.TrimPrefix(name, "$")]
+}
+
+// Has returns true if an argument was given for the placeholder.
+func (a *Args) Has(name string) bool {
+	_, ok := a.values[strings.TrimPrefix(name, "$")]
+	return ok
+}
+
+// Value returns the argument for a placeholder converted by its type's
+// validator, e.g. an int for $count:int, or nil if it wasn't given.
+// Arguments for placeholders without a validator are returned as strings.
+func (a *Args) Value(name string) interface{} {
+	return a.converted[strings.TrimPrefix(name, "$")]
+}
+
+// Options returns the keyword-value options given, e.g. {"count": "5"} for
+// "ping $host {count $n | size $s}*" called with "ping a count 5".
+func (a *Args) Options() map[string]string {
+	return a.options
+}
+
+// Rest returns the words matched by $*.
+func (a *Args) Rest() []string {
+	return a.rest
+}
+
+// Strings returns all of the arguments, in the order they're passed to an
+// ErrCommand.
+func (a *Args) Strings() []string {
+	return a.list
+}
+
+// Flag returns the value of a flag by its long name, or its default if it
+// wasn't given.  Boolean flags are "true" or "false".
+func (a *Args) Flag(name string) string {
+	if a.flags == nil {
+		return ""
+	}
+	return a.flags.values[name]
+}
+
+// FlagValue returns the value of a flag converted by its type, e.g. an int
+// for a flag of type "int" or a bool for a boolean flag.  nil is returned if
+// the flag wasn't given and has no default.
+func (a *Args) FlagValue(name string) interface{} {
+	if a.flags == nil {
+		return nil
+	}
+	return a.flags.converted[name]
+}
+
+// FlagGiven returns true if the flag was given by the user.
+func (a *Args) FlagGiven(name string) bool {
+	return a.flags != nil && a.flags.given[name]
+}
+
+// isNamed returns true if the segment is a named placeholder, e.g. $host.
+func (s segment) isNamed() bool {
+	r, _ := utf8.DecodeRuneInString(s.value)
+	return s.typ == placeholderType && unicode.IsLetter(r)
+}
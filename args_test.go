@@ -0,0 +1,71 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestArgsOptions(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	cs := p.NewCommandSet("foo")
+	cs.RegisterArgsCommandFunc("ping $host {count $count:int | size $size:int}*", func(w io.Writer, args *Args) error {
+		fmt.Fprintf(w, "%v %v", args.Options(), args.Value("size"))
+		return nil
+	})
+	p.Abbreviate = true
+
+	tests := []struct {
+		line string
+		out  string
+		err  string
+	}{
+		{"ping a", "map[] <nil>", ""},
+		{"ping a si 1400 c 5", "map[count:5 size:1400] 1400", ""},
+		{"ping a size 1400 size 1", "", "ping a size 1400 size 1: option size given twice"},
+		{"ping a size big", "", `ping a size big: invalid <size> "big": not an integer`},
+		{"ping a c 5 si 1 c 6", "", "ping a c 5 si 1 c 6: option count given twice"},
+		{"ping a count 5 x", "", "ping a count 5 x: command not found"},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.out || (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected %q (%s), got %q (%v)", tc.out, tc.err, out, err)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	cs := p.NewCommandSet("foo")
+	err := cs.RegisterArgsCommandFunc("ping $host:hostname [count $count:int] [{v4|v6}] $*", func(w io.Writer, args *Args) error {
+		count, _ := args.Value("count").(int)
+		fmt.Fprintf(w, "%s %v %d %q %q", args.Get("host"), args.Has("$count"), count, args.Rest(), args.Strings())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	tests := []struct {
+		line string
+		out  string
+		err  string
+	}{
+		{"ping example.com x", `example.com false 0 ["x"] ["example.com" "" "" "x"]`, ""},
+		{"ping example.com count 5 v6 x y", `example.com true 5 ["x" "y"] ["example.com" "5" "v6" "x" "y"]`, ""},
+		{"ping example.com count five x", "", `ping example.com count five x: invalid <count> "five": not an integer`},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.out || (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected %q (%s), got %q (%v)", tc.out, tc.err, out, err)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package prompt
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -21,12 +24,18 @@ const (
 	matchNone matchType = iota
 	matchWildcard
 	matchSubstitution
+	matchPrefix
 	matchExact
 )
 
 type command struct {
+	text    string // the description as registered
 	desc    input
-	execute Command
+	execute ArgsCommand
+	summary string            // one line help
+	usage   string            // detailed help
+	argHelp map[string]string // placeholder help, keyed by "$n" or "$*"
+	flags   []Flag
 }
 
 func (c *command) isWildcard() bool {
@@ -48,135 +57,375 @@ func (c *command) isSubstWildcard() bool {
 }
 
 func (c *command) execMatch(line input) matchType {
-	// input is longer than the command, and the command is not a wildcard
-	if len(line.words) > len(c.desc.words) && !c.isWildcard() {
-		return matchNone
+	return summarizeMatch(c.matchScores(line, false))
+}
+
+// matchScores matches user input against the command description, returning
+// how well each word of the input matched or nil if the input doesn't match.
+// If abbrev is true, keywords can be abbreviated to any prefix.
+func (c *command) matchScores(line input, abbrev bool) []matchType {
+	b := c.bind(&matcher{words: line.words, abbrev: abbrev})
+	if b == nil {
+		return nil
 	}
+	return b.scores
+}
 
-	// input is too short to match
-	if len(line.words) < len(c.desc.words) {
+// summarizeMatch returns the worst match type of a set of scores.
+func summarizeMatch(scores []matchType) matchType {
+	if len(scores) == 0 {
 		return matchNone
 	}
-
 	mt := matchExact
-	for i, w := range line.words {
-		// still matching, and we're now at a wildcard
-		if i >= len(c.desc.words) && c.isWildcard() {
-			return matchWildcard
-		}
-		switch matchesSegment(w, c.desc.words[i]) {
-		case matchNone:
-			return matchNone
-		case matchWildcard:
-			if mt == matchExact || mt == matchSubstitution {
-				mt = matchWildcard
-			}
-		case matchSubstitution:
-			if mt == matchExact {
-				mt = matchSubstitution
-			}
+	for _, s := range scores {
+		if s < mt {
+			mt = s
 		}
 	}
 	return mt
 }
 
-func (c *command) complete(line []segment, completers map[string]Completer) (completionType, []string) {
-	// no user input, so complete with the first word of each command
-	if len(line) == 0 && c.desc.words[0].typ == wordType {
-		return completeExact, []string{c.desc.words[0].value}
+// compareScores compares the scores of two matches word by word, returning
+// a positive number if a is the better match, negative if b is, and zero if
+// they are equally good.
+func compareScores(a, b []matchType) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return int(a[i]) - int(b[i])
+		}
 	}
+	return len(a) - len(b)
+}
 
-	// user input is longer than the command allows, and it's not
-	// a wildcard command
-	if len(line) > len(c.desc.words) && !c.isWildcard() {
-		return completeNone, nil
-	} else if len(line) >= len(c.desc.words) && c.isWildcard() {
-		last := c.desc.words[len(c.desc.words)-1]
-		// do we have a completer?
-		if completer, ok := completers[last.ctype]; ok {
-			lastInput := line[len(line)-1]
-			c := completer(lastInput.value)
-			// only one completion, and it's the text we matched against
-			if len(c) == 1 && c[0] == lastInput.value {
-				return completeNone, nil
-			}
-			return completePartial, c
+// complete returns completions for the last word of line.  If the last word
+// is partially typed, completePartial is returned with completions that
+// replace it.  If it exactly matches a keyword, completeExact is returned with
+// completions for the word following it.
+func (c *command) complete(line []segment, comp *completion) (completionType, []Completion) {
+	// no user input, so complete with the first segments of the command
+	if len(line) == 0 {
+		next, _ := c.nextSegments(&matcher{})
+		if words := c.candidates(next, "", nil, comp); len(words) > 0 {
+			return completeExact, words
 		}
 		return completeNone, nil
 	}
 
-	// find the last command description segment that matches
-	matchSegIdx := 0
-	mt := completeNone
-	for i, l := range line {
-		if mt = completionMatchSegment(l, c.desc.words[i]); mt == completeNone {
+	last := line[len(line)-1]
+	prior := line[:len(line)-1]
+	if len(c.flags) > 0 {
+		rest, _, pending, err := scanFlags(c.flags, prior, nil)
+		switch {
+		case err != nil:
+			return completeNone, nil
+		case pending != nil:
+			// complete the flag's value
+			cc := c.completionContext(comp, "--"+pending.Name, rest)
+			if words := comp.complete(cc, pending.Type, last.value, pending.Help); len(words) > 0 {
+				return completePartial, words
+			}
+			return completeNone, nil
+		case strings.HasPrefix(last.value, "-"):
+			if words := completeFlags(c.flags, last.value); len(words) > 0 && !(len(words) == 1 && words[0].Text == last.value) {
+				return completePartial, words
+			}
 			return completeNone, nil
 		}
-		matchSegIdx = i
+		prior = rest
+		line = append(append([]segment(nil), prior...), last)
 	}
-
-	// exact match for all segments we checked
-	if mt == completeExact {
-		// already fully matches this command and there are no segments left
-		if matchSegIdx == len(c.desc.words)-1 {
-			return completeNone, nil
+	next, _ := c.nextSegments(&matcher{words: prior})
+	partial := []Completion{}
+	exact := false
+	for _, di := range next {
+		seg := c.desc.words[di]
+		if kw := seg.keyword(last.value); kw != "" && kw == last.value {
+			// offer the other keywords it's a prefix of
+			exact = true
+			for _, kw := range seg.keywords() {
+				if kw != last.value && strings.HasPrefix(kw, last.value) {
+					partial = append(partial, c.describeKeyword(di, kw, comp))
+				}
+			}
+			continue
 		}
-		// offer the next segment as a completion
-		matchSegIdx++
+		words := c.candidates([]int{di}, last.value, prior, comp)
+		// only one completion, and it's the text we matched against
+		if len(words) == 1 && words[0].Text == last.value {
+			continue
+		}
+		partial = append(partial, words...)
+	}
+	if len(partial) > 0 {
+		return completePartial, partial
 	}
 
-	matchSeg := c.desc.words[matchSegIdx]
-	// are we matching a $n, or $* segment?
-	if matchSeg.typ == placeholderType {
-		completer, ok := completers[matchSeg.ctype]
-		// unknown or no completer for this placeholder
-		if !ok {
-			return completeNone, nil
+	// offer the segments following the keyword
+	if exact {
+		next, _ := c.nextSegments(&matcher{words: line})
+		if words := c.candidates(next, "", line, comp); len(words) > 0 {
+			return completeExact, words
 		}
+	}
+	return completeNone, nil
+}
 
-		var words []string
-		if matchSegIdx >= len(line) {
-			words = completer("")
-		} else {
-			words = completer(line[matchSegIdx].value)
+// candidates returns the completions of prefix for the segments at indices,
+// which follow the words in prior.
+func (c *command) candidates(indices []int, prefix string, prior []segment, comp *completion) []Completion {
+	words := []Completion{}
+	for _, di := range indices {
+		seg := c.desc.words[di]
+		if seg.typ != placeholderType {
+			for _, kw := range seg.keywords() {
+				if strings.HasPrefix(kw, prefix) {
+					words = append(words, c.describeKeyword(di, kw, comp))
+				}
+			}
+			continue
 		}
-		if len(words) == 0 {
-			return completeNone, nil
+		// unknown or no completer for this placeholder
+		cc := c.completionContext(comp, "$"+seg.value, prior)
+		desc := c.argHelp["$"+seg.value]
+		if desc == "" {
+			desc = seg.display()
 		}
-		return mt, words
+		words = append(words, comp.complete(cc, seg.ctype, prefix, desc)...)
 	}
+	return words
+}
 
-	// not a placeholder, so just return the single next segment
-	return completePartial, []string{matchSeg.value}
+// describeKeyword returns a completion of the keyword kw of the segment at
+// di, described by the command's summary if the command can end after it.
+func (c *command) describeKeyword(di int, kw string, comp *completion) Completion {
+	help := ""
+	if c.canEnd(di+1, 0) {
+		help = c.summary
+	}
+	return Completion{kw, comp.describeKeyword(kw, help)}
 }
 
-func matchesSegment(input, cmd segment) matchType {
+// completionContext returns the context for completing the argument arg
+// following the words in prior, which are bound to the command's
+// placeholders as far as they match.
+func (c *command) completionContext(comp *completion, arg string, prior []segment) *CompletionContext {
+	cc := &CompletionContext{Command: c.text, Arg: arg, Args: &Args{}}
+	if comp != nil {
+		cc.Context, cc.Mode = comp.ctx, comp.mode
+	}
+	b := c.bindPrefix(&matcher{words: prior})
+	if b == nil {
+		return cc
+	}
+	args, err := c.args(b, comp.argTypes())
+	if err != nil {
+		// pass the invalid arguments unconverted
+		args, _ = c.args(b, nil)
+	}
+	cc.Args = args
+	return cc
+}
+
+func matchesSegment(input, cmd segment, abbrev bool) matchType {
 	if cmd.typ == placeholderType {
 		if cmd.value == "*" {
 			return matchWildcard
 		}
 		return matchSubstitution
 	}
-	if cmd.value == input.value {
+	switch kw := cmd.keyword(input.value); {
+	case kw == "":
+		return matchNone
+	case kw == input.value:
 		return matchExact
+	case abbrev:
+		return matchPrefix
 	}
 	return matchNone
 }
 
-func completionMatchSegment(input, cmd segment) completionType {
-	if cmd.typ == placeholderType {
-		return completePartial
+// shape returns a string describing the sequence of keywords and
+// placeholders a command matches.  Commands with the same shape match exactly
+// the same input equally well.
+func (c *command) shape() string {
+	parts := []string{}
+	for di := 0; di < len(c.desc.words); di++ {
+		w := c.desc.words[di]
+		if w.kv != 0 {
+			parts = append(parts, c.optionsShape(di))
+			di = c.optionsEnd(di) - 1
+			continue
+		}
+		part := w.shapePart()
+		if c.startsGroup(di) {
+			part = "[" + part
+		}
+		if w.opt != 0 && c.groupEnd(di) == di+1 {
+			part += "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// expansions returns the shapes of each form of the command, with and
+// without each of its optional groups and with each keyword of its
+// alternations.
+func (c *command) expansions() [][]string {
+	forms := [][]string{{}}
+	for di := 0; di < len(c.desc.words); {
+		if c.desc.words[di].kv != 0 {
+			// the options are compared as a whole, with or without them
+			next := [][]string{}
+			for _, f := range forms {
+				next = append(next, append(append([]string(nil), f...), c.optionsShape(di)))
+			}
+			forms = append(next, forms...)
+			di = c.optionsEnd(di)
+			continue
+		}
+		end := di + 1
+		if c.startsGroup(di) {
+			end = c.groupEnd(di)
+		}
+		// each keyword of an alternation is a separate form
+		next := forms
+		for _, w := range c.desc.words[di:end] {
+			parts := w.keywords()
+			if w.typ == placeholderType {
+				parts = []string{w.shapePart()}
+			}
+			grown := [][]string{}
+			for _, f := range next {
+				for _, part := range parts {
+					grown = append(grown, append(append([]string(nil), f...), part))
+				}
+				// a variadic that can match no words
+				if w.isWildcard() && w.min == 0 {
+					grown = append(grown, f)
+				}
+			}
+			next = grown
+		}
+		if c.startsGroup(di) {
+			next = append(next, forms...)
+		}
+		forms = next
+		di = end
+	}
+	return forms
+}
+
+// optionsShape returns the shape of the keyword-value group starting at di.
+// The options are sorted, as their order doesn't matter.
+func (c *command) optionsShape(di int) string {
+	options := []string{}
+	for end := c.optionsEnd(di); di < end; di += 2 {
+		options = append(options, c.desc.words[di].value+" "+c.desc.words[di+1].shapePart())
+	}
+	sort.Strings(options)
+	return "{" + strings.Join(options, " | ") + "}*"
+}
+
+// overlaps returns true if some form of the command has the same shape as a
+// form of other, or can match the same input equally well.
+func (c *command) overlaps(other *command) bool {
+	for _, a := range c.expansions() {
+		for _, b := range other.expansions() {
+			if sameShape(a, b) || sameSpecificity(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameShape returns true if the parts of two command shapes match the same
+// input.  Variadic placeholders are the same if the number of words they
+// match can be the same.
+func sameShape(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		var amin, amax, bmin, bmax int
+		_, aerr := fmt.Sscanf(a[i], "$*%d,%d", &amin, &amax)
+		_, berr := fmt.Sscanf(b[i], "$*%d,%d", &bmin, &bmax)
+		if aerr != nil || berr != nil {
+			if a[i] != b[i] {
+				return false
+			}
+			continue
+		}
+		if (amax >= 0 && amax < bmin) || (bmax >= 0 && bmax < amin) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameSpecificity returns true if the parts of two command shapes match the
+// same input with keywords in each matched by placeholders in the other, and
+// both have the same number of keywords, e.g. "d x $" and "d $ x" for "d x x".
+// Neither is more specific, so neither could be preferred.
+func sameSpecificity(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	if cmd.value == input.value {
-		return completeExact
+	akw, bkw := 0, 0
+	for i := range a {
+		switch {
+		case a[i] == b[i]:
+		case a[i] == "$" && isKeywordPart(b[i]):
+			bkw++
+		case b[i] == "$" && isKeywordPart(a[i]):
+			akw++
+		default:
+			return false
+		}
 	}
-	if strings.HasPrefix(cmd.value, input.value) {
-		return completePartial
+	return akw == bkw
+}
+
+// isKeywordPart returns true if the part of a command shape is a keyword.
+func isKeywordPart(part string) bool {
+	return !strings.HasPrefix(part, "$") && !strings.HasPrefix(part, "{")
+}
+
+// shapePart returns the part of a command shape describing the segment.
+func (s segment) shapePart() string {
+	switch {
+	case s.typ != placeholderType:
+		return s.value
+	case s.isWildcard():
+		// the number of words matched, at least one as it's optional if
+		// it can match none
+		min := s.min
+		if min == 0 {
+			min = 1
+		}
+		return fmt.Sprintf("$*%d,%d", min, s.max)
+	default:
+		return "$"
 	}
-	return completeNone
 }
 
-func parseCommand(desc string, fn Command) (*command, error) {
+// validate checks that the command description can be matched as written.
+func (c *command) validate() error {
+	for i, w := range c.desc.words {
+		if !w.isWildcard() || i == len(c.desc.words)-1 {
+			continue
+		}
+		// the keyword marks where the variadic's words end
+		next := c.desc.words[i+1]
+		if next.typ == placeholderType || next.opt != 0 || next.kv != 0 {
+			return errors.New("$* must be followed by a keyword or be the last segment of a command")
+		}
+	}
+	return nil
+}
+
+func parseCommand(desc string, fn ArgsCommand) (*command, error) {
 	cmd := &command{}
 	inp, err := parseCmdDescription(desc)
 	if err != nil {
@@ -185,7 +434,162 @@ func parseCommand(desc string, fn Command) (*command, error) {
 	if len(inp) != 1 {
 		return nil, errors.New("expected a single command")
 	}
+	cmd.text = desc
 	cmd.desc = inp[0]
 	cmd.execute = fn
+	if err := cmd.validate(); err != nil {
+		return nil, err
+	}
 	return cmd, nil
 }
+
+// args returns the arguments for the command from a binding of user input.
+// Placeholders are ordered by their index, and other arguments by their
+// position in the description.  An alternation is passed as the keyword
+// chosen.  An optional group of keywords is passed as the keywords if present
+// and an empty string otherwise, and an optional placeholder or alternation
+// that isn't present is passed as an empty string, so each argument keeps
+// its position.  Keyword-value option values are only passed by keyword.  The
+// words matched by a $* come last.  Arguments for typed placeholders are validated and converted.
+func (c *command) args(b *binding, types map[string]ArgType) (*Args, error) {
+	bound := make([][]string, len(c.desc.words))
+	for wi, di := range b.segs {
+		bound[di] = append(bound[di], b.words[wi].value)
+	}
+
+	a := &Args{values: map[string]string{}, converted: map[string]interface{}{}, options: map[string]string{}, flags: b.flags}
+	for wi, di := range b.segs {
+		// a keyword-value option is always followed by its value
+		if seg := c.desc.words[di]; seg.kv != 0 && seg.typ == wordType && wi+1 < len(b.words) {
+			a.options[seg.value] = b.words[wi+1].value
+		}
+	}
+	args := []string{}
+	indices := []int{}
+	for di := 0; di < len(c.desc.words); di++ {
+		seg := c.desc.words[di]
+		if c.startsGroup(di) && c.keywordGroup(di) {
+			end := c.groupEnd(di)
+			arg := ""
+			if bound[di] != nil {
+				keywords := []string{}
+				for _, w := range c.desc.words[di:end] {
+					keywords = append(keywords, w.value)
+				}
+				arg = strings.Join(keywords, " ")
+			}
+			args = append(args, arg)
+			indices = append(indices, len(indices)+1)
+			di = end - 1
+			continue
+		}
+
+		switch {
+		case seg.isWildcard():
+			// a $* consumes the rest of the arguments
+			for _, w := range bound[di] {
+				if _, err := convertArg(seg, w, types); err != nil {
+					return nil, err
+				}
+			}
+			a.rest = append(a.rest, bound[di]...)
+		case seg.typ == alternationType:
+			// the keyword chosen, in full
+			arg := ""
+			if len(bound[di]) > 0 {
+				arg = seg.keyword(bound[di][0])
+			}
+			args = append(args, arg)
+			indices = append(indices, len(indices)+1)
+		case seg.typ == placeholderType:
+			arg := ""
+			if len(bound[di]) > 0 {
+				arg = bound[di][0]
+				v, err := convertArg(seg, arg, types)
+				if err != nil {
+					return nil, err
+				}
+				a.values[seg.value] = arg
+				a.converted[seg.value] = v
+			}
+			if seg.kv != 0 {
+				// option values are only passed by keyword
+				continue
+			}
+			idx, err := strconv.Atoi(seg.value)
+			if err != nil {
+				// named placeholders are ordered by position
+				idx = len(indices) + 1
+			}
+			args = append(args, arg)
+			indices = append(indices, idx)
+		}
+	}
+	// sort our argument list by the placeholder indices
+	sort.Stable(argSort{indices, args})
+	a.list = append(args, a.rest...)
+	return a, nil
+}
+
+// checkArgs validates the arguments bound to placeholders with a registered
+// type.
+func (c *command) checkArgs(words []segment, b *binding, types map[string]ArgType) error {
+	for wi, di := range b.segs {
+		seg := c.desc.words[di]
+		if seg.typ != placeholderType {
+			continue
+		}
+		if _, err := convertArg(seg, words[wi].value, types); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertArg validates and converts an argument for the placeholder seg.
+// Arguments for placeholders without a validator are returned unchanged.
+func convertArg(seg segment, arg string, types map[string]ArgType) (interface{}, error) {
+	t, ok := types[seg.ctype]
+	if !ok || t.Validator == nil {
+		return arg, nil
+	}
+	v, err := t.Validator(arg)
+	if err != nil {
+		return nil, &ArgError{seg.display(), arg, err}
+	}
+	return v, nil
+}
+
+// withoutFlags returns the words that aren't the command's flags, or the
+// words unchanged if the flags are invalid.
+func (c *command) withoutFlags(words []segment) []segment {
+	rest, _, _, err := scanFlags(c.flags, words, nil)
+	if err != nil {
+		return words
+	}
+	return rest
+}
+
+// keywordGroup returns true if the optional group starting at di contains
+// only keywords.
+func (c *command) keywordGroup(di int) bool {
+	for _, w := range c.desc.words[di:c.groupEnd(di)] {
+		if w.typ != wordType {
+			return false
+		}
+	}
+	return true
+}
+
+// argSort is used for sorting input arguments by placeholder indices
+type argSort struct {
+	indices []int
+	args    []string
+}
+
+func (s argSort) Len() int           { return len(s.indices) }
+func (s argSort) Less(i, j int) bool { return s.indices[i] < s.indices[j] }
+func (s argSort) Swap(i, j int) {
+	s.indices[i], s.indices[j] = s.indices[j], s.indices[i]
+	s.args[i], s.args[j] = s.args[j], s.args[i]
+}
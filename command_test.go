@@ -1,6 +1,7 @@
 package prompt
 
 import (
+	"io"
 	"strings"
 	"testing"
 )
@@ -36,7 +37,7 @@ func TestCommandExecMatchWildcard(t *testing.T) {
 		expMatch matchType
 	}{
 		{"go", matchNone},
-		{"go score", matchNone},
+		{"go score", matchExact},
 		{"go score a", matchWildcard},
 		{"go score a b", matchWildcard},
 		{"go score a b c", matchWildcard},
@@ -51,6 +52,41 @@ func TestCommandExecMatchWildcard(t *testing.T) {
 	}
 }
 
+func TestCommandExecMatchVariadic(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		input    string
+		expMatch matchType
+	}{
+		{"go $+", "go", matchNone},
+		{"go $+", "go a", matchWildcard},
+		{"go $+", "go a b", matchWildcard},
+		{"go $*{2,3}", "go a", matchNone},
+		{"go $*{2,3}", "go a b", matchWildcard},
+		{"go $*{2,3}", "go a b c", matchWildcard},
+		{"go $*{2,3}", "go a b c d", matchNone},
+		{"go $*{2}", "go a b", matchWildcard},
+		{"go $*{2}", "go a b c", matchNone},
+		{"copy $+ to $1", "copy a to b", matchWildcard},
+		{"copy $+ to $1", "copy a b c to d", matchWildcard},
+		{"copy $+ to $1", "copy to d", matchNone},
+		{"copy $+ to $1", "copy a b", matchNone},
+		{"copy $* to $1", "copy to d", matchSubstitution},
+	}
+
+	for _, tc := range tests {
+		cmd, err := parseCommand(tc.cmd, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		inp, _ := parseUserInput(tc.input)
+		mt := cmd.execMatch(inp[0])
+		if mt != tc.expMatch {
+			t.Errorf("expected match=%v, got %v for %v with %v", tc.expMatch, mt, tc.input, tc.cmd)
+		}
+	}
+}
+
 func TestCommandExecMatchSubst(t *testing.T) {
 	cmd, err := parseCommand("go score $1 $2", nil)
 	if err != nil {
@@ -79,6 +115,159 @@ func TestCommandExecMatchSubst(t *testing.T) {
 	}
 }
 
+func TestCommandExecMatchOptional(t *testing.T) {
+	cmd, err := parseCommand("show [ip] route [$1] [detail $2]", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	tests := []struct {
+		input    string
+		expMatch matchType
+	}{
+		{"show", matchNone},
+		{"show route", matchExact},
+		{"show ip route", matchExact},
+		{"show ip", matchNone},
+		{"show route 10.0.0.0", matchSubstitution},
+		{"show route detail eth0", matchSubstitution},
+		{"show ip route 10.0.0.0 detail eth0", matchSubstitution},
+		{"show route detail", matchSubstitution},
+		{"show route 10.0.0.0 detail", matchNone},
+		{"show route a b c", matchNone},
+	}
+
+	for _, tc := range tests {
+		inp, _ := parseUserInput(tc.input)
+		mt := cmd.execMatch(inp[0])
+		if mt != tc.expMatch {
+			t.Errorf("expected match=%v, got %v for %v", tc.expMatch, mt, tc.input)
+		}
+	}
+}
+
+func TestCommandCompleteOptional(t *testing.T) {
+	cmd, err := parseCommand("show [ip] route [detail]", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	tests := []struct {
+		input       string
+		cType       completionType
+		completions []string
+	}{
+		{"show", completeExact, []string{"route", "ip"}},
+		{"show r", completePartial, []string{"route"}},
+		{"show ip", completeExact, []string{"route"}},
+		{"show route", completeExact, []string{"detail"}},
+		{"show route detail", completeNone, nil},
+	}
+
+	for _, tc := range tests {
+		inp, _ := parseUserInput(tc.input)
+		cType, described := cmd.complete(inp[0].words, nil)
+		completions := completionTexts(described)
+		if cType != tc.cType {
+			t.Errorf("expected ct=%s, got %s for %v", tc.cType, cType, tc.input)
+		}
+		if strings.Join(completions, " ") != strings.Join(tc.completions, " ") {
+			t.Errorf("expected %v, got %v for %v", tc.completions, completions, tc.input)
+		}
+	}
+}
+
+func TestCommandAlternation(t *testing.T) {
+	cmd, err := parseCommand("logging {on|off|once}", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	matches := []struct {
+		input    string
+		expMatch matchType
+	}{
+		{"logging", matchNone},
+		{"logging on", matchExact},
+		{"logging off", matchExact},
+		{"logging debug", matchNone},
+		{"logging on off", matchNone},
+	}
+	for _, tc := range matches {
+		inp, _ := parseUserInput(tc.input)
+		if mt := cmd.execMatch(inp[0]); mt != tc.expMatch {
+			t.Errorf("expected match=%v, got %v for %v", tc.expMatch, mt, tc.input)
+		}
+	}
+
+	completions := []struct {
+		input       string
+		cType       completionType
+		completions []string
+	}{
+		{"logging", completeExact, []string{"on", "off", "once"}},
+		{"logging o", completePartial, []string{"on", "off", "once"}},
+		{"logging on", completePartial, []string{"once"}},
+		{"logging of", completePartial, []string{"off"}},
+		{"logging off", completeNone, nil},
+	}
+	for _, tc := range completions {
+		inp, _ := parseUserInput(tc.input)
+		cType, described := cmd.complete(inp[0].words, nil)
+		completions := completionTexts(described)
+		if cType != tc.cType {
+			t.Errorf("expected ct=%s, got %s for %v", tc.cType, cType, tc.input)
+		}
+		if strings.Join(completions, " ") != strings.Join(tc.completions, " ") {
+			t.Errorf("expected %v, got %v for %v", tc.completions, completions, tc.input)
+		}
+	}
+}
+
+func TestCommandOptions(t *testing.T) {
+	cmd, err := parseCommand("ping $host {count $n:num | size $s | timeout $t}* $*", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	matches := []struct {
+		input    string
+		expMatch matchType
+	}{
+		{"ping a x", matchWildcard},
+		{"ping a count 5 x", matchWildcard},
+		{"ping a timeout 2 count 5 size 10 x", matchWildcard},
+		{"ping a count 5 count 6 x", matchWildcard},
+		{"ping a", matchSubstitution},
+	}
+	for _, tc := range matches {
+		inp, _ := parseUserInput(tc.input)
+		if mt := cmd.execMatch(inp[0]); mt != tc.expMatch {
+			t.Errorf("expected match=%v, got %v for %v", tc.expMatch, mt, tc.input)
+		}
+	}
+
+	completers := map[string]DescribedCompleter{"num": Completer(func(string) []string { return []string{"1", "2"} }).withContext().described()}
+	completions := []struct {
+		input       string
+		cType       completionType
+		completions []string
+	}{
+		{"ping a c", completePartial, []string{"count"}},
+		{"ping a count", completeExact, []string{"1", "2"}},
+		{"ping a count 5 size 1 t", completePartial, []string{"timeout"}},
+		{"ping a count 5 s", completePartial, []string{"size"}},
+		{"ping a count 5 c", completeNone, nil},
+	}
+	for _, tc := range completions {
+		inp, _ := parseUserInput(tc.input)
+		cType, described := cmd.complete(inp[0].words, &completion{completers: completers})
+		completions := completionTexts(described)
+		if cType != tc.cType {
+			t.Errorf("expected ct=%s, got %s for %v", tc.cType, cType, tc.input)
+		}
+		if strings.Join(completions, " ") != strings.Join(tc.completions, " ") {
+			t.Errorf("expected %v, got %v for %v", tc.completions, completions, tc.input)
+		}
+	}
+}
+
 func TestCommandCompletePlaceholder(t *testing.T) {
 	cmd, err := parseCommand("go score $1:test", nil)
 	if err != nil {
@@ -89,25 +278,26 @@ func TestCommandCompletePlaceholder(t *testing.T) {
 		cType       completionType
 		completions []string
 	}{
-		{"go", completePartial, []string{"score"}},
+		{"go", completeExact, []string{"score"}},
 		{"go score", completeExact, []string{"a", "b", "c"}},
 		{"go score foo", completeNone, nil},
 		{"go score foo bar", completeNone, nil},
 	}
 
-	completers := map[string]Completer{}
-	completers["test"] = func(s string) (r []string) {
+	completers := map[string]DescribedCompleter{}
+	completers["test"] = Completer(func(s string) (r []string) {
 		for _, p := range []string{"a", "b", "c"} {
 			if strings.HasPrefix(p, s) {
 				r = append(r, p)
 			}
 		}
 		return r
-	}
+	}).withContext().described()
 
 	for _, tc := range tests {
 		inp, _ := parseUserInput(tc.input)
-		cType, completions := cmd.complete(inp[0].words, completers)
+		cType, described := cmd.complete(inp[0].words, &completion{completers: completers})
+		completions := completionTexts(described)
 		if cType != tc.cType {
 			t.Errorf("expected ct=%s, got %s for %v", tc.cType, cType, tc.input)
 		}
@@ -132,22 +322,22 @@ func TestCommandCompletePlaceWildcard(t *testing.T) {
 		cType       completionType
 		completions []string
 	}{
-		{"go", completePartial, []string{"score"}},
+		{"go", completeExact, []string{"score"}},
 		{"go score", completeExact, []string{"a", "b", "c"}},
 		{"go score a", completeNone, nil},
 		{"go score a b", completeNone, nil},
 		{"", completeExact, []string{"go"}},
 	}
 
-	completers := map[string]Completer{}
-	completers["test"] = func(s string) (r []string) {
+	completers := map[string]DescribedCompleter{}
+	completers["test"] = Completer(func(s string) (r []string) {
 		for _, p := range []string{"a", "b", "c"} {
 			if strings.HasPrefix(p, s) {
 				r = append(r, p)
 			}
 		}
 		return r
-	}
+	}).withContext().described()
 
 	for _, tc := range tests {
 		inp, _ := parseUserInput(tc.input)
@@ -155,7 +345,8 @@ func TestCommandCompletePlaceWildcard(t *testing.T) {
 		if inp == nil || len(inp) == 0 {
 			inp = []input{{}}
 		}
-		cType, completions := cmd.complete(inp[0].words, completers)
+		cType, described := cmd.complete(inp[0].words, &completion{completers: completers})
+		completions := completionTexts(described)
 		if cType != tc.cType {
 			t.Errorf("expected ct=%s, got %s for %v", tc.cType, cType, tc.input)
 		}
@@ -169,3 +360,48 @@ func TestCommandCompletePlaceWildcard(t *testing.T) {
 		}
 	}
 }
+
+func TestRegisterConflicts(t *testing.T) {
+	cs := newCommandSet("test")
+	tests := []struct {
+		desc string
+		err  string
+	}{
+		{"show $1", ""},
+		{"show $*", ""},
+		{"show version", ""},
+		{"show $2", `"show $2" conflicts with "show $1"`},
+		{"show $*:host", `"show $*:host" conflicts with "show $*"`},
+		{"show version", `"show version" conflicts with "show version"`},
+		{"show $* $1", "$* must be followed by a keyword or be the last segment of a command"},
+		{"show $* version", `"show $* version" conflicts with "show version"`},
+		{"show $1 version", ""},
+		{"show [ip] route", ""},
+		{"show route", `"show route" conflicts with "show [ip] route"`},
+		{"show ip route [$1]", `"show ip route [$1]" conflicts with "show [ip] route"`},
+		{"log {on|off}", ""},
+		{"log {off|debug}", `"log {off|debug}" conflicts with "log {on|off}"`},
+		{"log debug", ""},
+		{"ping {count $1 | size $2}*", ""},
+		{"ping {size $3 | count $4}*", `"ping {size $3 | count $4}*" conflicts with "ping {count $1 | size $2}*"`},
+		{"ping", `"ping" conflicts with "ping {count $1 | size $2}*"`},
+		{"copy $+ to $1", ""},
+		{"copy $*{2} to $2", `"copy $*{2} to $2" conflicts with "copy $+ to $1"`},
+		{"move $*{1,2} to $1", ""},
+		{"move $*{3,} to $2", ""},
+		{"move to $3", ""},
+		{"move $*{0,1} to $4", `"move $*{0,1} to $4" conflicts with "move $*{1,2} to $1"`},
+		{"d x $1", ""},
+		{"d $1 x", `"d $1 x" conflicts with "d x $1"`},
+		{"d [x] $1 [y]", `"d [x] $1 [y]" conflicts with "d x $1"`},
+		{"d x $1 $2", ""},
+		{"d $1 y z", ""},
+		{"d $1 $2 z", `"d $1 $2 z" conflicts with "d x $1 $2"`},
+	}
+	for _, tc := range tests {
+		err := cs.RegisterCommandFunc(tc.desc, func(io.Writer, []string) {})
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected error %q registering %s, got %v", tc.err, tc.desc, err)
+		}
+	}
+}
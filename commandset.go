@@ -1,18 +1,33 @@
 package prompt
 
-import "io"
+import (
+	"fmt"
+	"io"
+)
 
 // Command is a function representing a command to be executed.  Arguments are
 // passed as args, and all output should be written to w to allow for
 // filtering.
 type Command func(w io.Writer, args []string)
 
+// ErrCommand is a command that can fail.  A non-nil error is reported by the
+// prompt's ErrorHandler and recorded as the prompt's last error.
+type ErrCommand func(w io.Writer, args []string) error
+
+// ArgsCommand is a command that is passed its arguments by name, see Args.
+type ArgsCommand func(w io.Writer, args *Args) error
+
 // CommandSet is a set of commands, usually related.  Command sets can be
 // switched between by registering commands that call PushCommandSet/PopCommandSet
 // on the Prompt.
 type CommandSet struct {
-	name     string
-	commands []*command
+	OnEnter        func(ctx interface{}) error  // called when the command set is pushed, an error prevents entering it
+	OnExit         func(ctx interface{})        // called when the command set is popped
+	PromptFragment func(ctx interface{}) string // returns the command set's part of the prompt, see Prompt.ModePrompt
+	name           string
+	commands       []*command
+	parent         *CommandSet       // commands not found here are looked up in the parent
+	keywordHelp    map[string]string // descriptions of keywords shared by several commands
 }
 
 func newCommandSet(name string) *CommandSet {
@@ -29,12 +44,140 @@ func newCommandSet(name string) *CommandSet {
 // reorder arguments (e.g. "foo $2 $1", when called with "foo a b", will
 // have []args{"b","a"}) when the command is executed.)
 //
-// $* - wildcard, matches all arguments to the end of the line
+// $name - a named placeholder, name starts with a letter and matches a single
+// argument.  Named placeholders are passed in order of appearance, and are
+// easiest to use with RegisterArgsCommandFunc (e.g. "ping $host:hostname",
+// where args.Get("host") returns the host).
+//
+// $* - wildcard, matches any number of arguments, including none.  It must be
+// the last segment or be followed by a keyword, which ends the arguments it
+// matches (e.g. "copy $* to $1").
+//
+// $+ - like $*, but matches at least one argument.
+//
+// $*{min,max} - like $*, but matches between min and max arguments.  {n}
+// matches exactly n arguments and {n,} matches at least n.
+//
+// The completionType of a placeholder names a Completer or an ArgType
+// registered with the Prompt.  Arguments for a placeholder with an ArgType
+// are validated before the command runs (e.g. "ping $1:ip").
+//
+// {a|b|...} - alternation, matches any one of the keywords, which is passed as
+// an argument (e.g. "logging {on|off}", when called with "logging off", will
+// have []args{"off"}).
+//
+// {kw $a | kw2 $b}* - keyword-value options, each keyword is followed by a
+// value for its placeholder, and they can be given in any order, each at
+// most once (e.g. "ping $host {count $n:int | size $s:int}*").  The values
+// aren't passed with the other arguments, use RegisterArgsCommandFunc and
+// Args.Options or Args.Get to fetch them.
+//
+// [...] - optional, the keywords and placeholders within the brackets may be
+// omitted (e.g. "show interface [detail] [$1]").  An optional placeholder or
+// alternation that isn't given is passed as an empty string, so each keeps
+// its position in the arguments, and an optional group of keywords is passed
+// as a single argument: the keywords if given, or an empty string.
+//
+// When input matches more than one command, the command matching a keyword
+// at the earliest word is run (e.g. "d x $1 $2" rather than "d $1 y z" for
+// "d x y z").
+//
+// An error is returned if the description is invalid, or if it matches the
+// same input as an already registered command equally well (e.g. "show $1"
+// and "show $2", or "d x $1" and "d $1 x").
 func (cs *CommandSet) RegisterCommandFunc(desc string, fn Command) error {
-	cmd, err := parseCommand(desc, fn)
+	return cs.RegisterErrCommandFunc(desc, func(w io.Writer, args []string) error {
+		fn(w, args)
+		return nil
+	})
+}
+
+// RegisterErrCommandFunc registers a command that returns an error. The
+// description syntax is the same as for RegisterCommandFunc.
+func (cs *CommandSet) RegisterErrCommandFunc(desc string, fn ErrCommand) error {
+	return cs.RegisterCommand(CommandDef{Desc: desc, Run: fn})
+}
+
+// RegisterArgsCommandFunc registers a command that is passed its arguments by
+// name. The description syntax is the same as for RegisterCommandFunc.
+func (cs *CommandSet) RegisterArgsCommandFunc(desc string, fn ArgsCommand) error {
+	return cs.RegisterCommand(CommandDef{Desc: desc, RunArgs: fn})
+}
+
+// CommandDef describes a command and its help text.
+type CommandDef struct {
+	Desc    string            // the command description, see RegisterCommandFunc
+	Summary string            // a one line summary of the command
+	Usage   string            // detailed usage information
+	ArgHelp map[string]string // placeholder descriptions, keyed by placeholder (e.g. "$1" or "$*")
+	Run     ErrCommand        // the command to run
+	RunArgs ArgsCommand       // the command to run, passed its arguments by name, used instead of Run if set
+	Flags   []Flag            // the flags the command accepts, see Flag
+}
+
+// RegisterCommand registers a command along with its help text.
+func (cs *CommandSet) RegisterCommand(def CommandDef) error {
+	run := def.RunArgs
+	if run == nil && def.Run != nil {
+		fn := def.Run
+		run = func(w io.Writer, args *Args) error {
+			return fn(w, args.Strings())
+		}
+	}
+	cmd, err := parseCommand(def.Desc, run)
 	if err != nil {
 		return err
 	}
+	cmd.summary = def.Summary
+	cmd.usage = def.Usage
+	cmd.argHelp = def.ArgHelp
+	if err := validateFlags(def.Flags); err != nil {
+		return err
+	}
+	cmd.flags = def.Flags
+	// a command with the same shape as an existing one could never be
+	// chosen over it
+	for _, other := range cs.commands {
+		if other.overlaps(cmd) {
+			return fmt.Errorf("%q conflicts with %q", def.Desc, other.text)
+		}
+	}
 	cs.commands = append(cs.commands, cmd)
 	return nil
 }
+
+// DescribeKeyword sets the help text displayed for a keyword that starts
+// several commands, e.g. "show".
+func (cs *CommandSet) DescribeKeyword(keyword, help string) {
+	if cs.keywordHelp == nil {
+		cs.keywordHelp = map[string]string{}
+	}
+	cs.keywordHelp[keyword] = help
+}
+
+// Name returns the name the command set was registered with.
+func (cs *CommandSet) Name() string {
+	return cs.name
+}
+
+// SetParent sets the parent of the command set.  Commands from the parent, and
+// its parents, can be used while the command set is current unless a command
+// in the set itself matches the user input.
+func (cs *CommandSet) SetParent(parent *CommandSet) error {
+	for p := parent; p != nil; p = p.parent {
+		if p == cs {
+			return fmt.Errorf("command set %s can't be a parent of itself", cs.name)
+		}
+	}
+	cs.parent = parent
+	return nil
+}
+
+// lineage returns the command set followed by its parents, innermost first.
+func (cs *CommandSet) lineage() []*CommandSet {
+	sets := []*CommandSet{}
+	for p := cs; p != nil; p = p.parent {
+		sets = append(sets, p)
+	}
+	return sets
+}
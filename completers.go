@@ -1,10 +1,13 @@
 package prompt
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // Completer is used to provide context-sensitive completions for placeholders.
@@ -12,6 +15,226 @@ import (
 // the registered placeholder types.
 type Completer func(arg string) []string
 
+// CompletionContext describes the input a ContextCompleter is completing an
+// argument for.
+type CompletionContext struct {
+	Context context.Context // done if the completions are no longer needed
+	Command string          // the description of the command being completed
+	Arg     string          // the placeholder or flag being completed, e.g. "$2" or "--count"
+	Args    *Args           // the arguments typed before the one being completed
+	Mode    interface{}     // the context of the current mode, see Prompt.ModeContext
+}
+
+// ContextCompleter is a Completer that is also given the command being
+// completed and the arguments already typed, e.g. to complete the VLANs of
+// the switch named by an earlier argument.
+type ContextCompleter func(cc *CompletionContext, arg string) []string
+
+// Completion is a completion of the word being typed, with a short
+// description shown when several completions are listed.
+type Completion struct {
+	Text        string // the text that replaces the word
+	Description string // e.g. the help for a command or the meaning of a value
+}
+
+// DescribedCompleter is a ContextCompleter that also describes each of its
+// completions.
+type DescribedCompleter func(cc *CompletionContext, arg string) []Completion
+
+// withContext returns the completer as a ContextCompleter that ignores the
+// context.
+func (fn Completer) withContext() ContextCompleter {
+	return func(_ *CompletionContext, arg string) []string {
+		return fn(arg)
+	}
+}
+
+// described returns the completer as a DescribedCompleter whose completions
+// have no description.
+func (fn ContextCompleter) described() DescribedCompleter {
+	return func(cc *CompletionContext, arg string) []Completion {
+		return plainCompletions(fn(cc, arg))
+	}
+}
+
+// plainCompletions returns completions of words without descriptions.
+func plainCompletions(words []string) []Completion {
+	completions := []Completion{}
+	for _, w := range words {
+		completions = append(completions, Completion{Text: w})
+	}
+	return completions
+}
+
+// completionTexts returns the text of each completion.
+func completionTexts(completions []Completion) []string {
+	texts := []string{}
+	for _, c := range completions {
+		texts = append(texts, c.Text)
+	}
+	return texts
+}
+
+// CompleterOptions control how a registered completer is called.
+type CompleterOptions struct {
+	// Timeout is how long to wait for completions.  The completer's context
+	// is done after it, and completers that stop then can return the
+	// completions found so far.  Zero waits until the completer returns.
+	Timeout time.Duration
+	// CacheTTL is how long completions are reused for the same command,
+	// arguments, prefix and mode context, or zero to call the completer
+	// every time.  Completions aren't cached in a mode entered with a
+	// context that can't be compared, e.g. a slice.
+	CacheTTL time.Duration
+}
+
+// completerGrace is how long a completer has to return the completions found
+// so far once its timeout has passed.
+const completerGrace = 50 * time.Millisecond
+
+// completionCache holds completions by completer name, command, arguments,
+// prefix and mode context.
+type completionCache map[cacheKey]cachedCompletions
+
+type cacheKey struct {
+	mode interface{} // the context of the mode completed in
+	text string      // the completer name, command, arguments and prefix
+}
+
+type cachedCompletions struct {
+	completions []Completion
+	expires     time.Time
+}
+
+// get returns the completions cached for key, if they haven't expired.
+func (c completionCache) get(key cacheKey) ([]Completion, bool) {
+	cached, ok := c[key]
+	if !ok || time.Now().After(cached.expires) {
+		return nil, false
+	}
+	return cached.completions, true
+}
+
+// put caches completions for key for ttl, and drops expired completions.
+func (c completionCache) put(key cacheKey, completions []Completion, ttl time.Duration) {
+	now := time.Now()
+	for k, cached := range c {
+		if now.After(cached.expires) {
+			delete(c, k)
+		}
+	}
+	c[key] = cachedCompletions{completions, now.Add(ttl)}
+}
+
+// newCacheKey returns the key for completions of prefix by the completer
+// name in the context cc, and false if the mode context can't be compared so
+// the completions can't be cached.
+func newCacheKey(name string, cc *CompletionContext, prefix string) (cacheKey, bool) {
+	parts := append([]string{name, cc.Command}, cc.Args.Strings()...)
+	key := cacheKey{cc.Mode, strings.Join(append(parts, prefix), "\x00")}
+	return key, cc.Mode == nil || reflect.TypeOf(cc.Mode).Comparable()
+}
+
+// completion is the state used to complete placeholder arguments.
+type completion struct {
+	ctx         context.Context
+	mode        interface{} // the context of the current mode
+	completers  map[string]DescribedCompleter
+	options     map[string]CompleterOptions
+	cache       completionCache
+	types       map[string]ArgType
+	keywordHelp map[string]string // keyword descriptions of the command set being completed
+}
+
+// complete returns the completions of prefix using the completer for ctype,
+// or nil if there isn't one.  Completions without a description are
+// described as desc.
+func (comp *completion) complete(cc *CompletionContext, ctype, prefix, desc string) []Completion {
+	if comp == nil {
+		return nil
+	}
+	fn, ok := comp.completers[ctype]
+	if !ok {
+		return nil
+	}
+	opts := comp.options[ctype]
+	key, cacheable := newCacheKey(ctype, cc, prefix)
+	var found []Completion
+	cached := false
+	if cacheable {
+		found, cached = comp.cache.get(key)
+	}
+	if !cached {
+		found, ok = callCompleter(fn, cc, prefix, opts.Timeout)
+		if ok && cacheable && opts.CacheTTL > 0 && comp.cache != nil {
+			comp.cache.put(key, found, opts.CacheTTL)
+		}
+	}
+	completions := []Completion{}
+	for _, c := range found {
+		if c.Description == "" {
+			c.Description = desc
+		}
+		completions = append(completions, c)
+	}
+	return completions
+}
+
+// callCompleter calls fn with a context that is done after timeout, unless
+// it's zero, returning the completions and true if fn completed in time.
+// Completions returned shortly after the timeout are returned with false.
+func callCompleter(fn DescribedCompleter, cc *CompletionContext, prefix string, timeout time.Duration) ([]Completion, bool) {
+	parent := cc.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(parent)
+		defer cancel()
+		cc.Context = ctx
+		return fn(cc, prefix), true
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	cc.Context = ctx
+	result := make(chan []Completion, 1)
+	go func() {
+		result <- fn(cc, prefix)
+	}()
+	select {
+	case completions := <-result:
+		return completions, ctx.Err() == nil
+	case <-ctx.Done():
+	}
+	// give the completer a chance to return what it has found so far
+	select {
+	case completions := <-result:
+		return completions, false
+	case <-time.After(completerGrace):
+		return nil, false
+	}
+}
+
+// describeKeyword returns the description of a keyword, which is the
+// command set's description of it if there is one and help otherwise.
+func (comp *completion) describeKeyword(kw, help string) string {
+	if comp != nil {
+		if kh, ok := comp.keywordHelp[kw]; ok {
+			return kh
+		}
+	}
+	return help
+}
+
+// argTypes returns the registered argument types.
+func (comp *completion) argTypes() map[string]ArgType {
+	if comp == nil {
+		return nil
+	}
+	return comp.types
+}
+
 type completeMode byte
 
 const (
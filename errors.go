@@ -0,0 +1,84 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrCommandNotFound is reported when user input doesn't match any command.
+var ErrCommandNotFound = errors.New("command not found")
+
+// ErrUnterminatedCommand is reported when the input ends part way through a
+// command, e.g. after a line ending with a backslash.
+var ErrUnterminatedCommand = errors.New("unterminated command")
+
+// CommandError is the error reported when running a line of user input fails.
+type CommandError struct {
+	Line string // the user input, as entered
+	Err  error  // the underlying error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Line, e.Err)
+}
+
+// ErrorHandler is called to report errors to the user.
+type ErrorHandler func(w io.Writer, err error)
+
+// DefaultErrorHandler writes the error to w on a line by itself.
+func DefaultErrorHandler(w io.Writer, err error) {
+	fmt.Fprintf(w, "%s\n", err)
+}
+
+// AmbiguousError is reported when user input matches several commands
+// equally well, e.g. an abbreviation of more than one keyword.
+type AmbiguousError struct {
+	Input      string   // the ambiguous part of the input
+	Candidates []string // what the input could refer to
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("ambiguous command %q, could be: %s", e.Input, strings.Join(e.Candidates, ", "))
+}
+
+// newAmbiguousError builds an error describing the first word of the input
+// where the matching commands differ.
+func newAmbiguousError(inp input, cmds []*command, bindings []*binding) *AmbiguousError {
+	for i, w := range bindings[0].words {
+		keywords := []string{}
+		seen := map[string]bool{}
+		for j, cmd := range cmds {
+			if i >= len(bindings[j].segs) {
+				continue
+			}
+			seg := cmd.desc.words[bindings[j].segs[i]]
+			if kw := seg.keyword(w.value); kw != "" && !seen[kw] {
+				seen[kw] = true
+				keywords = append(keywords, kw)
+			}
+		}
+		if len(keywords) > 1 {
+			return &AmbiguousError{w.value, keywords}
+		}
+	}
+
+	// no single word was ambiguous, so list the commands themselves
+	descs := []string{}
+	for _, cmd := range cmds {
+		descs = append(descs, cmd.text)
+	}
+	return &AmbiguousError{inp.asUser(), descs}
+}
+
+// ArgError is reported when an argument fails validation.
+type ArgError struct {
+	Arg   string // the placeholder, as shown in help, e.g. "<int>"
+	Value string // the argument given
+	Err   error  // why it's invalid
+}
+
+func (e *ArgError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Arg, e.Value, e.Err)
+}
@@ -2,6 +2,9 @@ package prompt_test
 
 import (
 	"bytes"
+	"context"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/tzneal/prompt"
@@ -32,3 +35,31 @@ func TestGrep(t *testing.T) {
 		}
 	}
 }
+
+func TestGrepFilterMatchesGrep(t *testing.T) {
+	lines := []string{"show", "show | grep foo", "show | grep -i FOO", "show | grep bar foo",
+		"show | grep foo bar", "show | grep -v foo bar", "show | grep \"a foo\""}
+	for _, line := range lines {
+		outs := []string{}
+		for _, def := range []bool{false, true} {
+			p := prompt.NewPromptWithReader(prompt.NewReaderLineReader(strings.NewReader(""), nil))
+			if def {
+				p.RegisterFilterDef(prompt.GrepFilter)
+			} else {
+				p.RegisterFilter("grep", prompt.Grep)
+			}
+			p.NewCommandSet("foo").RegisterCommandFunc("show", func(w io.Writer, args []string) {
+				io.WriteString(w, "a foo\nfoo\nbar\nFoo\n")
+			})
+			out, err := p.Execute(context.Background(), line)
+			if err != nil {
+				t.Errorf("expected no error, got %s for %s", err, line)
+			}
+			outs = append(outs, out)
+			p.Close()
+		}
+		if outs[0] != outs[1] {
+			t.Errorf("expected Grep output %q, got %q from GrepFilter for %s", outs[0], outs[1], line)
+		}
+	}
+}
@@ -12,12 +12,59 @@ import (
 // the result to the w.
 type Filter func(r io.Reader, w io.Writer, args []string)
 
+// ArgsFilter is a filter that is passed its arguments and flags, see Args.
+type ArgsFilter func(r io.Reader, w io.Writer, args *Args)
+
+// FilterDef describes a filter and the flags it accepts.
+type FilterDef struct {
+	Name      string           // the name the filter is used by
+	Help      string           // a description of the filter, shown when completing its name
+	Flags     []Flag           // the flags the filter accepts, see Flag
+	Completer ContextCompleter // completes the filter's arguments, may be nil
+	Run       ArgsFilter       // the filter to run
+}
+
+// filterArgs parses the arguments given to a filter.
+func (f *FilterDef) filterArgs(args []string, types map[string]ArgType) (*Args, error) {
+	words := []segment{}
+	for _, a := range args {
+		words = append(words, segment{value: a, typ: wordType})
+	}
+	rest, _, flags, err := parseFlags(f.Flags, words, types)
+	if err != nil {
+		return nil, err
+	}
+	a := &Args{values: map[string]string{}, converted: map[string]interface{}{}, flags: flags}
+	for _, w := range rest {
+		a.rest = append(a.rest, w.value)
+	}
+	a.list = a.rest
+	return a, nil
+}
+
 var nl = []byte{'\n'}
 
+// GrepFilter is Grep, with its -i and -v options declared as flags.  Like
+// Grep, the last argument is the regular expression.
+var GrepFilter = FilterDef{
+	Name: "grep",
+	Help: "only output lines matching a regular expression",
+	Flags: []Flag{
+		{Name: "ignore-case", Short: 'i', Help: "match without regard to case"},
+		{Name: "invert-match", Short: 'v', Help: "only output lines that don't match"},
+	},
+	Run: func(r io.Reader, w io.Writer, args *Args) {
+		rest := args.Strings()
+		if len(rest) == 0 {
+			io.Copy(w, r)
+			return
+		}
+		grep(r, w, rest[len(rest)-1], args.FlagValue("ignore-case") == true, args.FlagValue("invert-match") == true)
+	},
+}
+
 // Grep is a very simple grep.
 func Grep(r io.Reader, w io.Writer, args []string) {
-	sc := bufio.NewScanner(r)
-
 	// no filter
 	if len(args) == 0 {
 		io.Copy(w, r)
@@ -36,8 +83,14 @@ func Grep(r io.Reader, w io.Writer, args []string) {
 			regex = arg
 		}
 	}
+	grep(r, w, regex, !caseSensitive, invertMatch)
+}
 
-	if !caseSensitive {
+// grep writes the lines from r that match regex to w, or that don't if
+// invertMatch is true.
+func grep(r io.Reader, w io.Writer, regex string, ignoreCase, invertMatch bool) {
+	sc := bufio.NewScanner(r)
+	if ignoreCase {
 		regex = fmt.Sprintf("(?i)%s", regex)
 	}
 
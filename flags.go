@@ -0,0 +1,222 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Flag describes an option accepted by a command or filter, given as
+// --name, --name value or --name=value, or -s for a short name.  Flags may
+// appear anywhere in the arguments, and "--" ends them.
+type Flag struct {
+	Name    string // the long name, without dashes
+	Short   rune   // an optional single letter name
+	Type    string // the type of the flag's value, e.g. "int", or empty for a boolean flag that takes no value
+	Default string // the value of the flag if it isn't given
+	Help    string // a description of the flag
+}
+
+// isBool returns true if the flag doesn't take a value.
+func (f *Flag) isBool() bool {
+	return f.Type == ""
+}
+
+// display returns the flag as shown in help output.
+func (f *Flag) display() string {
+	s := "--" + f.Name
+	if f.Short != 0 {
+		s = "-" + string(f.Short) + ", " + s
+	}
+	if !f.isBool() {
+		s += " <" + f.Type + ">"
+	}
+	return s
+}
+
+// validateFlags checks that flag names are present and unique.
+func validateFlags(flags []Flag) error {
+	names := map[string]bool{}
+	shorts := map[rune]bool{}
+	for _, f := range flags {
+		if f.Name == "" || strings.HasPrefix(f.Name, "-") {
+			return fmt.Errorf("invalid flag name %q", f.Name)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("duplicate flag --%s", f.Name)
+		}
+		names[f.Name] = true
+		if f.Short != 0 {
+			if shorts[f.Short] {
+				return fmt.Errorf("duplicate flag -%c", f.Short)
+			}
+			shorts[f.Short] = true
+		}
+	}
+	return nil
+}
+
+// flagSet holds the values of the flags of a command or filter.
+type flagSet struct {
+	values    map[string]string      // flag values by name, including defaults
+	converted map[string]interface{} // flag values converted by their type
+	given     map[string]bool        // the flags given by the user
+}
+
+// set sets the value of a flag, validating it if it has a registered type.
+func (fs *flagSet) set(f *Flag, value string, types map[string]ArgType) error {
+	var v interface{} = value
+	if f.isBool() {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return &ArgError{"--" + f.Name, value, errors.New("not true or false")}
+		}
+		v = b
+	} else if t, ok := types[f.Type]; ok && t.Validator != nil {
+		var err error
+		if v, err = t.Validator(value); err != nil {
+			return &ArgError{"--" + f.Name, value, err}
+		}
+	}
+	fs.values[f.Name] = value
+	fs.converted[f.Name] = v
+	return nil
+}
+
+// parseFlags separates the flags in words from the other words, returning
+// the remaining words, their indices in words and the flag values.
+func parseFlags(flags []Flag, words []segment, types map[string]ArgType) ([]segment, []int, *flagSet, error) {
+	fs := &flagSet{
+		values:    map[string]string{},
+		converted: map[string]interface{}{},
+		given:     map[string]bool{},
+	}
+	for i := range flags {
+		f := &flags[i]
+		def := f.Default
+		if f.isBool() && def == "" {
+			def = "false"
+		}
+		if def == "" {
+			continue
+		}
+		if err := fs.set(f, def, types); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	rest, kept, pending, err := scanFlags(flags, words, func(f *Flag, value string) error {
+		fs.given[f.Name] = true
+		return fs.set(f, value, types)
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if pending != nil {
+		return nil, nil, nil, fmt.Errorf("flag --%s needs a value", pending.Name)
+	}
+	return rest, kept, fs, nil
+}
+
+// scanFlags separates the flags in words from the other words, calling set,
+// if not nil, for each flag given.  If the last word is a flag missing its
+// value, the flag is returned as pending.
+func scanFlags(flags []Flag, words []segment, set func(f *Flag, value string) error) (rest []segment, kept []int, pending *Flag, err error) {
+	if set == nil {
+		set = func(*Flag, string) error { return nil }
+	}
+	for i := 0; i < len(words); i++ {
+		w := words[i].value
+		if len(flags) == 0 || !isFlag(w) {
+			rest = append(rest, words[i])
+			kept = append(kept, i)
+			continue
+		}
+		// everything after -- is an argument
+		if w == "--" {
+			for i++; i < len(words); i++ {
+				rest = append(rest, words[i])
+				kept = append(kept, i)
+			}
+			break
+		}
+
+		name, value, hasValue := w, "", false
+		if eq := strings.Index(w, "="); eq >= 0 {
+			name, value, hasValue = w[:eq], w[eq+1:], true
+		}
+		var given []*Flag
+		if strings.HasPrefix(name, "--") {
+			f := lookupFlag(flags, name[2:], 0)
+			if f == nil {
+				return nil, nil, nil, fmt.Errorf("unknown flag %s", name)
+			}
+			given = append(given, f)
+		} else {
+			// several short boolean flags can be combined, e.g. -iv
+			for _, r := range name[1:] {
+				f := lookupFlag(flags, "", r)
+				if f == nil {
+					return nil, nil, nil, fmt.Errorf("unknown flag -%c", r)
+				}
+				given = append(given, f)
+			}
+		}
+
+		for j, f := range given {
+			switch {
+			case f.isBool() && (!hasValue || j < len(given)-1):
+				err = set(f, "true")
+			case hasValue && j == len(given)-1:
+				err = set(f, value)
+			case j < len(given)-1:
+				return nil, nil, nil, fmt.Errorf("flag -%c needs a value", f.Short)
+			case i+1 < len(words):
+				i++
+				err = set(f, words[i].value)
+			default:
+				pending = f
+			}
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	return rest, kept, pending, nil
+}
+
+// lookupFlag returns the flag with the long name or short name given.
+func lookupFlag(flags []Flag, name string, short rune) *Flag {
+	for i := range flags {
+		if (name != "" && flags[i].Name == name) || (short != 0 && flags[i].Short == short) {
+			return &flags[i]
+		}
+	}
+	return nil
+}
+
+// isFlag returns true if the word looks like a flag, and not a negative
+// number.
+func isFlag(w string) bool {
+	if len(w) < 2 || w[0] != '-' {
+		return false
+	}
+	_, err := strconv.ParseFloat(w, 64)
+	return err != nil
+}
+
+// completeFlags returns the names of the flags that start with prefix,
+// described by their help.
+func completeFlags(flags []Flag, prefix string) []Completion {
+	names := []Completion{}
+	for _, f := range flags {
+		if long := "--" + f.Name; strings.HasPrefix(long, prefix) {
+			names = append(names, Completion{long, f.Help})
+		}
+		if short := "-" + string(f.Short); f.Short != 0 && strings.HasPrefix(short, prefix) {
+			names = append(names, Completion{short, f.Help})
+		}
+	}
+	return names
+}
@@ -0,0 +1,109 @@
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func buildFlagPrompt(t *testing.T) *Prompt {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	if err := p.RegisterFilterDef(GrepFilter); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	cs := p.NewCommandSet("foo")
+	err := cs.RegisterCommand(CommandDef{
+		Desc:    "ping $host",
+		Summary: "ping a host",
+		Flags: []Flag{
+			{Name: "count", Short: 'c', Type: "int", Default: "5", Help: "number of pings"},
+			{Name: "verbose", Short: 'v', Help: "show each reply"},
+			{Name: "format", Type: "string", Help: "output format"},
+		},
+		RunArgs: func(w io.Writer, args *Args) error {
+			fmt.Fprintf(w, "%s %v %v %v %q\n", args.Get("host"), args.FlagValue("count"), args.FlagValue("verbose"),
+				args.FlagGiven("count"), args.Flag("format"))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	return p
+}
+
+func TestCommandFlags(t *testing.T) {
+	p := buildFlagPrompt(t)
+	defer p.Close()
+
+	tests := []struct {
+		line string
+		out  string
+		err  string
+	}{
+		{"ping a", "a 5 false false \"\"\n", ""},
+		{"ping -v a --count 3", "a 3 true true \"\"\n", ""},
+		{"ping --format=json -vc 2 a", "a 2 true true \"json\"\n", ""},
+		{"ping --verbose=false a", "a 5 false false \"\"\n", ""},
+		{"ping -- -v", "-v 5 false false \"\"\n", ""},
+		{"ping -x a", "", "ping -x a: unknown flag -x"},
+		{"ping a --count", "", "ping a --count: flag --count needs a value"},
+		{"ping a -c five", "", `ping a -c five: invalid --count "five": not an integer`},
+		{"ping -cv 2 a", "", "ping -cv 2 a: flag -c needs a value"},
+		{"ping -v a | grep -i A", "a 5 true false \"\"\n", ""},
+		{"ping -v a | grep -v a", "", ""},
+		{"ping -v a | grep -x a", "", "ping -v a | grep -x a: grep: unknown flag -x"},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.out || (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected %q (%s), got %q (%v)", tc.out, tc.err, out, err)
+		}
+	}
+}
+
+func TestCommandFlagsHelp(t *testing.T) {
+	p := buildFlagPrompt(t)
+	defer p.Close()
+
+	tests := []struct {
+		line string
+		exp  string
+	}{
+		{"help ping", "usage: ping $host\n\nping a host\n\nflags:\n" +
+			"  -c, --count <int>  number of pings (default 5)\n" +
+			"  -v, --verbose      show each reply\n" +
+			"  --format <string>  output format\n"},
+		{"ping --c?", "  -c, --count <int>  number of pings\n"},
+		{"ping -v ?", "  <host>  \n"},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.exp || err != nil {
+			t.Errorf("expected %q, got %q (%v) for %s", tc.exp, out, err, tc.line)
+		}
+	}
+
+	term := &bytes.Buffer{}
+	p.LineReader = NewReaderLineReader(strings.NewReader(""), term)
+	completions := []struct {
+		line   string
+		exp    []string
+		listed []string
+	}{
+		{"ping --", []string{"ping --"}, []string{"--count", "--format", "--verbose"}},
+		{"ping -v --v", []string{"ping -v --verbose"}, nil},
+		{"ping --format ", nil, nil},
+	}
+	for _, tc := range completions {
+		if got := p.tabCompleter(tc.line); strings.Join(got, ",") != strings.Join(tc.exp, ",") {
+			t.Errorf("expected %v, got %v for %s", tc.exp, got, tc.line)
+		}
+		if listed := listedCompletions(term); strings.Join(listed, ",") != strings.Join(tc.listed, ",") {
+			t.Errorf("expected %v listed, got %v for %s", tc.listed, listed, tc.line)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package prompt
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+)
+
+// newBuiltins returns the command set of commands available everywhere.
+func (p *Prompt) newBuiltins() *CommandSet {
+	cs := newCommandSet("builtins")
+	cs.RegisterCommand(CommandDef{
+		Desc:    "help $*",
+		Summary: "list the available commands or show help for one",
+		ArgHelp: map[string]string{"$*": "the command"},
+		Run:     p.help,
+	})
+	return cs
+}
+
+// help lists the commands matching args, with detailed help if only a single
+// command matches.
+func (p *Prompt) help(w io.Writer, args []string) error {
+	words := []segment{}
+	for _, a := range args {
+		words = append(words, segment{value: a, typ: wordType})
+	}
+
+	cmds := []*command{}
+	shapes := map[string]bool{}
+	for _, cmd := range p.availableCommands() {
+		// commands in outer command sets are hidden by those in inner ones
+		if shapes[cmd.shape()] || !cmd.prefixMatches(words, p.Abbreviate) {
+			continue
+		}
+		shapes[cmd.shape()] = true
+		cmds = append(cmds, cmd)
+	}
+
+	switch len(cmds) {
+	case 0:
+		return ErrCommandNotFound
+	case 1:
+		if len(args) > 0 {
+			cmds[0].writeHelp(w)
+			return nil
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, cmd := range cmds {
+		fmt.Fprintf(tw, "%s\t%s\n", cmd.text, cmd.summary)
+	}
+	return tw.Flush()
+}
+
+// writeHelp writes the detailed help for the command.
+func (c *command) writeHelp(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s\n", c.text)
+	if c.summary != "" {
+		fmt.Fprintf(w, "\n%s\n", c.summary)
+	}
+	if c.usage != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(c.usage))
+	}
+	if len(c.argHelp) != 0 {
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, seg := range c.desc.words {
+			if seg.typ == placeholderType {
+				fmt.Fprintf(tw, "  %s\t%s\n", seg.display(), c.argHelp["$"+seg.value])
+			}
+		}
+		tw.Flush()
+	}
+	if len(c.flags) != 0 {
+		fmt.Fprintln(w, "\nflags:")
+		writeFlagHelp(w, c.flags)
+	}
+}
+
+// writeFlagHelp writes a line of help for each flag.
+func writeFlagHelp(w io.Writer, flags []Flag) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for i := range flags {
+		f := &flags[i]
+		help := f.Help
+		if f.Default != "" {
+			help += fmt.Sprintf(" (default %s)", f.Default)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", f.display(), strings.TrimSpace(help))
+	}
+	tw.Flush()
+}
+
+// prefixMatches returns true if words match the start of the command.
+func (c *command) prefixMatches(words []segment, abbrev bool) bool {
+	next, complete := c.nextSegments(&matcher{words: words, abbrev: abbrev})
+	return complete || len(next) > 0
+}
+
+// display returns the segment as shown in help output.
+func (s segment) display() string {
+	if s.typ != placeholderType {
+		return s.value
+	}
+	name := "arg"
+	if s.ctype != "" {
+		name = s.ctype
+	}
+	if s.isNamed() {
+		name = s.value
+	}
+	if s.value == "*" {
+		return "<" + name + "...>"
+	}
+	return "<" + name + ">"
+}
+
+// isHelpRequest returns true if the user is asking for help with the input
+// typed so far, e.g. "show ?" or "sh?".  A quoted or escaped '?', or one at
+// the end of a filter or output file name, isn't a help request.
+func isHelpRequest(line string) bool {
+	line = strings.TrimSpace(line)
+	if !strings.HasSuffix(line, "?") {
+		return false
+	}
+	rest := strings.TrimSuffix(line, "?")
+	if escaped := len(rest) - len(strings.TrimRight(rest, "\\")); escaped%2 == 1 {
+		return false
+	}
+	// an unterminated quote means the '?' is quoted
+	parsed, err := parseUserInput(rest)
+	if err != nil {
+		return false
+	}
+	if len(parsed) == 0 {
+		return true
+	}
+	last := parsed[len(parsed)-1]
+	return len(last.filters) == 0 && last.outputFile == ""
+}
+
+// hasContextHelp returns true if line is a help request and there is help to
+// show for it.  Other lines ending in '?' are run as commands.
+func (p *Prompt) hasContextHelp(line string) bool {
+	return isHelpRequest(line) && p.contextHelp(line, ioutil.Discard) == nil
+}
+
+// contextHelp lists the keywords and placeholders that can follow the input
+// before the trailing '?', along with their descriptions.
+func (p *Prompt) contextHelp(line string, w io.Writer) error {
+	line = strings.TrimSuffix(strings.TrimSpace(line), "?")
+	parsed, err := parseUserInput(line)
+	if err != nil {
+		return fmt.Errorf("parse error: %s", err)
+	}
+	var prior []segment
+	if len(parsed) > 0 && !strings.HasSuffix(strings.TrimSpace(line), ";") {
+		prior = parsed[len(parsed)-1].words
+	}
+	// completing a partially typed word?
+	partial := ""
+	if len(prior) > 0 && !strings.HasSuffix(line, " ") {
+		partial = prior[len(prior)-1].value
+		prior = prior[:len(prior)-1]
+	}
+
+	type helpItem struct {
+		text string
+		help string
+	}
+	items := []*helpItem{}
+	byText := map[string]*helpItem{}
+	var cr *helpItem
+	add := func(text, help string) {
+		if it, ok := byText[text]; ok {
+			if it.help == "" {
+				it.help = help
+			}
+			return
+		}
+		it := &helpItem{text, help}
+		byText[text] = it
+		if text == "<cr>" {
+			// listed last, like networking equipment
+			cr = it
+		} else {
+			items = append(items, it)
+		}
+	}
+
+	shapes := map[string]bool{}
+	for _, cs := range p.commandSetsInScope() {
+		for _, cmd := range cs.commands {
+			if shapes[cmd.shape()] || !cmd.prefixMatches(cmd.withoutFlags(prior), p.Abbreviate) {
+				continue
+			}
+			shapes[cmd.shape()] = true
+
+			if strings.HasPrefix(partial, "-") {
+				for i := range cmd.flags {
+					f := &cmd.flags[i]
+					if strings.HasPrefix("--"+f.Name, partial) || (f.Short != 0 && strings.HasPrefix("-"+string(f.Short), partial)) {
+						add(f.display(), f.Help)
+					}
+				}
+			}
+			next, complete := cmd.nextSegments(&matcher{words: cmd.withoutFlags(prior), abbrev: p.Abbreviate})
+			if complete && partial == "" {
+				add("<cr>", cmd.summary)
+			}
+			for _, di := range next {
+				seg := cmd.desc.words[di]
+				if seg.typ == placeholderType {
+					if partial == "" {
+						add(seg.display(), cmd.argHelp["$"+seg.value])
+					}
+					continue
+				}
+				for _, kw := range seg.keywords() {
+					if !strings.HasPrefix(kw, partial) {
+						continue
+					}
+					help := cmd.summary
+					if !cmd.canEnd(di+1, 0) {
+						// the keyword starts a longer command
+						help = ""
+					}
+					if kh, ok := cs.keywordHelp[kw]; ok {
+						help = kh
+					}
+					add(kw, help)
+				}
+			}
+		}
+	}
+	if cr != nil {
+		items = append(items, cr)
+	}
+	if len(items) == 0 {
+		return &CommandError{line + "?", ErrCommandNotFound}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, it := range items {
+		fmt.Fprintf(tw, "  %s\t%s\n", it.text, it.help)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,122 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func buildHelpPrompt(t *testing.T) *Prompt {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	cs := p.NewCommandSet("foo")
+	cs.DescribeKeyword("show", "show system information")
+	noop := func(io.Writer, []string) error { return nil }
+	defs := []CommandDef{
+		{Desc: "show version", Summary: "show the software version", Run: noop},
+		{Desc: "show interface $1:iface", Summary: "show an interface", Usage: "Shows interface counters.",
+			ArgHelp: map[string]string{"$1": "the interface name"}, Run: noop},
+		{Desc: "show route [$1:prefix]", Summary: "show routes", Run: noop},
+		{Desc: "shutdown", Summary: "power off", Run: noop},
+		{Desc: "exit", Run: noop},
+	}
+	for _, def := range defs {
+		if err := cs.RegisterCommand(def); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	}
+	return p
+}
+
+func TestHelpCommand(t *testing.T) {
+	p := buildHelpPrompt(t)
+	defer p.Close()
+
+	tests := []struct {
+		line string
+		exp  string
+		err  string
+	}{
+		{"help", "show version             show the software version\n" +
+			"show interface $1:iface  show an interface\n" +
+			"show route [$1:prefix]   show routes\n" +
+			"shutdown                 power off\n" +
+			"exit                     \n" +
+			"help $*                  list the available commands or show help for one\n", ""},
+		{"help show", "show version             show the software version\n" +
+			"show interface $1:iface  show an interface\n" +
+			"show route [$1:prefix]   show routes\n", ""},
+		{"help show interface", "usage: show interface $1:iface\n\nshow an interface\n\n" +
+			"Shows interface counters.\n\n  <iface>  the interface name\n", ""},
+		{"help foo", "", "help foo: command not found"},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.exp {
+			t.Errorf("expected output %q, got %q", tc.exp, out)
+		}
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestContextHelp(t *testing.T) {
+	p := buildHelpPrompt(t)
+	defer p.Close()
+
+	tests := []struct {
+		line string
+		exp  string
+		err  string
+	}{
+		{"?", "  show      show system information\n  shutdown  power off\n  exit      \n  help      list the available commands or show help for one\n", ""},
+		{"sh?", "  show      show system information\n  shutdown  power off\n", ""},
+		{"show ?", "  version    show the software version\n  interface  \n  route      show routes\n", ""},
+		{"show route ?", "  <prefix>  \n  <cr>      show routes\n", ""},
+		{"show interface ?", "  <iface>  the interface name\n", ""},
+		{"show interface eth0 ?", "  <cr>  show an interface\n", ""},
+		{"show foo?", "", "show foo?: command not found"},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.exp {
+			t.Errorf("expected output %q, got %q for %s", tc.exp, out, tc.line)
+		}
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+
+	if isHelpRequest("show | grep a?") {
+		t.Errorf("expected a filter argument not to be a help request")
+	}
+}
+
+func TestContextHelpFallback(t *testing.T) {
+	p := buildHelpPrompt(t)
+	defer p.Close()
+	p.CurrentCommandSet().RegisterCommandFunc("echo $*", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "%q", args)
+	})
+
+	tests := []struct {
+		line string
+		exp  string
+	}{
+		{"ec?", "  echo  \n"},
+		{"echo ?", "  <arg...>  \n  <cr>      \n"},
+		{"echo what?", `["what?"]`},
+		{`echo a\?`, `["a?"]`},
+		{`echo a\\?`, `["a\\?"]`},
+		{`echo "b?"`, `["b?"]`},
+		{`echo 'c ?'`, `["c ?"]`},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if err != nil || out != tc.exp {
+			t.Errorf("expected %q, got %q (%v) for %s", tc.exp, out, err, tc.line)
+		}
+	}
+}
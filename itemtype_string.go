@@ -4,9 +4,9 @@ package prompt
 
 import "fmt"
 
-const _itemType_name = "itemChanCloseitemErroritemWorditemSemiitemQuotedStringitemLineContitemFilenameitemPlaceholderitemCompletionTypeitemPipeitemRAngleitemEOF"
+const _itemType_name = "itemChanCloseitemErroritemWorditemSemiitemQuotedStringitemLineContitemFilenameitemPlaceholderitemCompletionTypeitemPipeitemRAngleitemLBracketitemRBracketitemLBraceitemRBraceitemAltSepitemEOF"
 
-var _itemType_index = [...]uint8{0, 13, 22, 30, 38, 54, 66, 78, 93, 111, 119, 129, 136}
+var _itemType_index = [...]uint8{0, 13, 22, 30, 38, 54, 66, 78, 93, 111, 119, 129, 141, 153, 163, 173, 183, 190}
 
 func (i itemType) String() string {
 	if i >= itemType(len(_itemType_index)-1) {
@@ -1,7 +1,9 @@
 package prompt
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -23,6 +25,11 @@ const (
 	itemCompletionType
 	itemPipe
 	itemRAngle
+	itemLBracket
+	itemRBracket
+	itemLBrace
+	itemRBrace
+	itemAltSep
 	itemEOF
 )
 
@@ -42,6 +49,9 @@ type lexer struct {
 	pos   int       // current position in the input.
 	width int       // width of last rune read from input.
 	items chan item // channel of scanned items.
+	alt   bool      // inside an alternation group
+	depth int       // the number of open brace blocks in user input
+	quote rune      // the delimiter of an unterminated quoted string
 }
 
 type lexStateFn func(*lexer) lexStateFn
@@ -123,65 +133,91 @@ func (l *lexer) backup() {
 	l.pos -= l.width
 }
 
-// lexQuote returns a function scans a quoted string.
-func lexQuote(delimeter rune) func(l *lexer) lexStateFn {
-
-	return func(l *lexer) lexStateFn {
-	Loop:
-		for {
-			switch l.next() {
-			case '\\':
-				if r := l.next(); r != eof && r != '\n' {
-					break
-				}
-				fallthrough
-			case eof, '\n':
-				return l.errorf("unterminated quoted string")
-			case delimeter:
-				break Loop
+// scanQuote scans the rest of a quoted string started by delim, returning
+// false if it isn't terminated.  Only double quoted strings contain escapes.
+// The string may span several lines.
+func (l *lexer) scanQuote(delim rune) bool {
+	for {
+		switch r := l.next(); {
+		case r == eof:
+			return false
+		case r == '\\' && delim == '"':
+			if l.next() == eof {
+				return false
 			}
+		case r == delim:
+			return true
 		}
-		l.emit(itemQuotedString)
-		return lexCommand
 	}
 }
 
-func lexWord(l *lexer) lexStateFn {
+// scanWord scans a word, which may contain quoted strings and backslash
+// escapes, e.g. "a b"c or a\ b.  It returns true if the word is quoted or
+// escaped.
+func (l *lexer) scanWord() (bool, error) {
+	quoted := false
 	for {
-		r := l.next()
+		switch r := l.next(); {
+		case r == '\\' && isLineCont(l.peek()):
+			// a line continuation ends the word, leave the backslash for
+			// lexCommand
+			l.pos--
+			return quoted, nil
+		case r == '\\':
+			l.next()
+			quoted = true
+		case isQuote(r):
+			if !l.scanQuote(r) {
+				l.quote = r
+				return quoted, errors.New("unterminated quoted string")
+			}
+			quoted = true
+		case l.isWord(r):
+		default:
+			l.backup()
+			return quoted, nil
+		}
+	}
+}
 
-		if !isWord(r) {
-			break
+func lexWord(l *lexer) lexStateFn {
+	// start again from the first rune, which is part of the word even if it
+	// isn't a word character
+	l.pos = l.start
+	if r := l.peek(); !l.isWord(r) && !isQuote(r) {
+		l.next()
+	}
+	quoted, err := l.scanWord()
+	if err != nil {
+		return l.errorf("%s", err)
+	}
+	if quoted {
+		l.emit(itemQuotedString)
+	} else {
+		if l.mode == userInputMode {
+			l.depth += braces(l.input[l.start:l.pos])
 		}
+		l.emit(itemWord)
 	}
-	l.backup()
-	l.emit(itemWord)
 	return lexCommand
 }
 
 func lexFilename(l *lexer) lexStateFn {
 	l.skipSpace()
-	for {
-		switch r := l.next(); {
-		case isWord(r):
-			continue
-
-		case isSpace(r):
-			l.emit(itemFilename)
-			return lexCommand
-
-		case r == ';':
-			l.backup()
-			l.emit(itemFilename)
-			return lexCommand
+	if _, err := l.scanWord(); err != nil {
+		return l.errorf("%s", err)
+	}
+	switch r := l.peek(); {
+	case isSpace(r) || r == ';' || r == '\\' || isEndOfLine(r):
+		l.emit(itemFilename)
+		return lexCommand
 
-		case r == eof:
-			l.emit(itemFilename)
-			return nil
+	case r == eof:
+		l.emit(itemFilename)
+		return nil
 
-		default:
-			return l.errorf("unexpected filename character '%c'", r)
-		}
+	default:
+		return l.errorf("unexpected filename character '%c'", r)
 	}
 }
 
@@ -225,7 +261,16 @@ func lexPlaceholder(l *lexer) lexStateFn {
 	l.ignore()
 	for {
 		switch r := l.next(); {
-		case r == '*':
+		case r == '*' || r == '+':
+			// an optional count, e.g. $*{1,3}
+			if l.peek() == '{' {
+				l.next()
+				for r := l.next(); r != '}'; r = l.next() {
+					if !isDigit(r) && r != ',' {
+						return l.errorf("invalid placeholder count")
+					}
+				}
+			}
 			l.emit(itemPlaceholder)
 			if l.peek() == ':' {
 				return lexCompletionType
@@ -242,6 +287,15 @@ func lexPlaceholder(l *lexer) lexStateFn {
 				return lexCompletionType
 			}
 			return lexCommand
+		case unicode.IsLetter(r):
+			for isAlphaNumeric(l.peek()) {
+				l.next()
+			}
+			l.emit(itemPlaceholder)
+			if l.peek() == ':' {
+				return lexCompletionType
+			}
+			return lexCommand
 		default:
 			return l.errorf("invalid placeholder character '%c'", r)
 		}
@@ -252,14 +306,10 @@ func lexCommand(l *lexer) lexStateFn {
 	for {
 		l.skipSpace()
 		switch r := l.next(); {
-		case r == '`':
-			return lexQuote('`')
-		case r == '"':
-			return lexQuote('"')
-		case r == '\'':
-			return lexQuote('\'')
 		case r == ';':
 			l.emit(itemSemi)
+		case l.alt && r == '|':
+			l.emit(itemAltSep)
 		case r == '|':
 			return lexFilter
 		case r == '>':
@@ -267,12 +317,29 @@ func lexCommand(l *lexer) lexStateFn {
 			return lexFilename
 		case l.mode == cmdDescMode && r == '$':
 			return lexPlaceholder
-		case r == '\\' && isEndOfLine(l.peek()):
+		case l.mode == cmdDescMode && r == '[':
+			l.emit(itemLBracket)
+		case l.mode == cmdDescMode && r == ']':
+			l.emit(itemRBracket)
+		case l.mode == cmdDescMode && r == '{':
+			l.alt = true
+			l.emit(itemLBrace)
+		case l.mode == cmdDescMode && r == '}':
+			l.alt = false
+			// }* ends a group of keyword-value options
+			if l.peek() == '*' {
+				l.next()
+			}
+			l.emit(itemRBrace)
+		case r == '\\' && isLineCont(l.peek()):
 			for isEndOfLine(l.peek()) {
 				l.next()
-				l.ignore()
 			}
+			l.ignore()
 			l.emit(itemLineCont)
+		case isEndOfLine(r) && l.depth > 0:
+			// commands continue to the end of a brace block
+			l.ignore()
 		case isEndOfLine(r):
 			l.ignore()
 			l.emit(itemSemi)
@@ -294,15 +361,40 @@ func isEndOfLine(r rune) bool {
 	return r == '\r' || r == '\n'
 }
 
+// isLineCont reports whether a backslash followed by r continues the input
+// on the next line.
+func isLineCont(r rune) bool {
+	return isEndOfLine(r) || r == eof
+}
+
 // isAlphaNumeric reports whether r is an alphabetic, digit, or underscore.
 func isAlphaNumeric(r rune) bool {
 	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
 }
 
+// isQuote reports whether r starts a quoted string.
+func isQuote(r rune) bool {
+	return r == '"' || r == '\'' || r == '`'
+}
+
 func isDigit(r rune) bool {
 	return unicode.IsDigit(r)
 }
 
+// isWord reports whether r is part of a word in the current lex mode.
+func (l *lexer) isWord(r rune) bool {
+	if l.mode == cmdDescMode && isDescDelim(r) {
+		return false
+	}
+	return isWord(r)
+}
+
+// isDescDelim reports whether r is a delimiter only used in command
+// descriptions.
+func isDescDelim(r rune) bool {
+	return r == '[' || r == ']' || r == '{' || r == '}'
+}
+
 func isWord(r rune) bool {
 	// being very lenient here for now
 	switch r {
@@ -312,3 +404,43 @@ func isWord(r rune) bool {
 		return true
 	}
 }
+
+// braces returns the number of braces a word of user input opens, less the
+// number it closes.
+func braces(word string) int {
+	return strings.Count(word, "{") - strings.Count(word, "}")
+}
+
+// lineState is what a line of user input leaves open for the next line.
+type lineState struct {
+	quote rune // the delimiter of an unterminated quoted string, or 0
+	depth int  // the number of unclosed brace blocks
+	cont  bool // the line ends with a line continuation
+}
+
+// incomplete reports whether the input needs another line to complete it.
+func (s lineState) incomplete() bool {
+	return s.quote != 0 || s.depth > 0 || s.cont
+}
+
+// next returns the state after line, which follows input that left state s.
+// Only line is lexed, so the lines of a long command are each lexed once.
+func (s lineState) next(line string) lineState {
+	if s.quote != 0 {
+		// carry on with the quoted string
+		line = string(s.quote) + line
+	}
+	l := &lexer{
+		input: line,
+		mode:  userInputMode,
+		items: make(chan item),
+		depth: s.depth,
+	}
+	go l.run()
+	last := item{}
+	for i := range l.items {
+		last = i
+	}
+	// the lexer is done once items is closed
+	return lineState{quote: l.quote, depth: l.depth, cont: last.typ == itemLineCont}
+}
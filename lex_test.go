@@ -44,6 +44,18 @@ func TestLexCommand(t *testing.T) {
 		{"  foo $* = bar $* >a.txt",
 			[]item{{itemWord, "foo"}, {itemPlaceholder, "*"}, {itemWord, "="}, {itemWord, "bar"}, {itemPlaceholder, "*"},
 				{itemRAngle, ">"}, {itemFilename, "a.txt"}}},
+		{"ping $host:hostname",
+			[]item{{itemWord, "ping"}, {itemPlaceholder, "host"}, {itemCompletionType, "hostname"}}},
+		{"copy $+ to $*{1,2}:file",
+			[]item{{itemWord, "copy"}, {itemPlaceholder, "+"}, {itemWord, "to"}, {itemPlaceholder, "*{1,2}"},
+				{itemCompletionType, "file"}}},
+		{"copy $*{1 to",
+			[]item{{itemWord, "copy"}, {itemError, "invalid placeholder count"}}},
+		{"ping {count $1}*",
+			[]item{{itemWord, "ping"}, {itemLBrace, "{"}, {itemWord, "count"}, {itemPlaceholder, "1"}, {itemRBrace, "}*"}}},
+		{"logging {on|off} [$1]",
+			[]item{{itemWord, "logging"}, {itemLBrace, "{"}, {itemWord, "on"}, {itemAltSep, "|"}, {itemWord, "off"},
+				{itemRBrace, "}"}, {itemLBracket, "["}, {itemPlaceholder, "1"}, {itemRBracket, "]"}}},
 		{"foo $*=bar $*>a.txt",
 			[]item{{itemWord, "foo"}, {itemPlaceholder, "*"}, {itemWord, "=bar"}, {itemPlaceholder, "*"},
 				{itemRAngle, ">"}, {itemFilename, "a.txt"}}}}
@@ -119,6 +131,22 @@ func TestLexUserInput(t *testing.T) {
 		{"foo $*=bar $*>a.txt",
 			[]item{{itemWord, "foo"}, {itemWord, "$*=bar"}, {itemWord, "$*"},
 				{itemRAngle, ">"}, {itemFilename, "a.txt"}}},
+		{`echo "a b"c 'd e' f\ g`,
+			[]item{{itemWord, "echo"}, {itemQuotedString, `"a b"c`}, {itemQuotedString, `'d e'`}, {itemQuotedString, `f\ g`}}},
+		{`echo "a \" b" 'c \' d`,
+			[]item{{itemWord, "echo"}, {itemQuotedString, `"a \" b"`}, {itemQuotedString, `'c \'`}, {itemWord, "d"}}},
+		{`echo a"b`, []item{{itemWord, "echo"}, {itemError, "unterminated quoted string"}}},
+		{"echo a\\\nb",
+			[]item{{itemWord, "echo"}, {itemWord, "a"}, {itemLineCont, ""}, {itemWord, "b"}}},
+		{`show > "my file.txt" | grep 'x y'`,
+			[]item{{itemWord, "show"}, {itemRAngle, ">"}, {itemFilename, `"my file.txt"`}, {itemPipe, "|"},
+				{itemWord, "grep"}, {itemQuotedString, "'x y'"}}},
+		{"echo a \\", []item{{itemWord, "echo"}, {itemWord, "a"}, {itemLineCont, ""}}},
+		{"echo \"a\nb\"\nc", []item{{itemWord, "echo"}, {itemQuotedString, "\"a\nb\""}, {itemSemi, ""}, {itemWord, "c"}}},
+		{"set {\na\n}\nb",
+			[]item{{itemWord, "set"}, {itemWord, "{"}, {itemWord, "a"}, {itemWord, "}"}, {itemSemi, ""}, {itemWord, "b"}}},
+		{"show > a.txt\nb",
+			[]item{{itemWord, "show"}, {itemRAngle, ">"}, {itemFilename, "a.txt"}, {itemSemi, ""}, {itemWord, "b"}}},
 	}
 
 	for _, tc := range testCases {
@@ -146,3 +174,49 @@ func TestLexUserInput(t *testing.T) {
 	}
 
 }
+
+func TestLineState(t *testing.T) {
+	testCases := []struct {
+		input string
+		exp   bool
+	}{
+		{"foo bar", false},
+		{"foo bar \\", true},
+		{"foo bar\\", true},
+		{"foo bar\\\\", false},
+		{"foo \\\nbar", false},
+		{"foo \"bar", true},
+		{"foo 'bar\nbaz", true},
+		{"foo 'bar\nbaz'", false},
+		{"foo \"{\"", false},
+		{"foo {", true},
+		{"foo {\na {\n}", true},
+		{"foo {\na {\n}\n}", false},
+		{"foo }", false},
+		{"foo | grep {", true},
+	}
+	for _, tc := range testCases {
+		if got := (lineState{}).next(tc.input).incomplete(); got != tc.exp {
+			t.Errorf("expected %v, got %v for %q", tc.exp, got, tc.input)
+		}
+	}
+
+	// lines after the first are lexed in the state left by the line before
+	lines := []struct {
+		line string
+		exp  lineState
+	}{
+		{"set { a \"b", lineState{quote: '"', depth: 1}},
+		{"c } ' d", lineState{quote: '"', depth: 1}},
+		{"e\" {", lineState{depth: 2}},
+		{"f } 'g", lineState{quote: '\'', depth: 1}},
+		{"' \\", lineState{depth: 1, cont: true}},
+		{"}", lineState{}},
+	}
+	var s lineState
+	for _, tc := range lines {
+		if s = s.next(tc.line); s != tc.exp {
+			t.Errorf("expected %+v, got %+v after %q", tc.exp, s, tc.line)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package prompt
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/peterh/liner"
+)
+
+// LineReader is the source of user input for a Prompt.
+type LineReader interface {
+	// Prompt displays prompt and returns the next line of input.  io.EOF is
+	// returned when there is no more input.
+	Prompt(prompt string) (string, error)
+	// AppendHistory adds an entry to the input history.
+	AppendHistory(item string)
+	// SetCompleter sets the function used for tab completion of a line.
+	SetCompleter(f func(line string) []string)
+	// Close releases any resources held by the reader.
+	Close() error
+}
+
+// suggester is implemented by line readers that can prefill the input line.
+type suggester interface {
+	PromptWithSuggestion(prompt string, text string, pos int) (string, error)
+}
+
+// wordCompleterSetter is implemented by line readers that complete the word
+// at the cursor, rather than the line before it.
+type wordCompleterSetter interface {
+	// SetWordCompleter sets the function used for tab completion of the word
+	// at the cursor, pos runes into line.  It returns the text before the
+	// word, its completions and the text after it.
+	SetWordCompleter(f func(line string, pos int) (head string, completions []string, tail string))
+}
+
+// terminalWriter is implemented by line readers that draw the prompt and
+// input line, so completions can be listed with them.
+type terminalWriter interface {
+	// Terminal returns the writer the prompt and input line are drawn on.
+	Terminal() io.Writer
+}
+
+// LinerReader is a LineReader that uses github.com/peterh/liner for line
+// editing, history and completion.  The liner state is embedded to allow
+// direct manipulation.
+type LinerReader struct {
+	*liner.State
+}
+
+// NewLinerReader returns a LineReader reading from the terminal using liner.
+func NewLinerReader() *LinerReader {
+	line := liner.NewLiner()
+	line.SetCtrlCAborts(true)
+	line.SetTabCompletionStyle(liner.TabPrints)
+	return &LinerReader{line}
+}
+
+// SetCompleter sets the liner completion function.
+func (l *LinerReader) SetCompleter(f func(line string) []string) {
+	l.State.SetCompleter(f)
+}
+
+// SetWordCompleter sets the liner completion function for the word at the
+// cursor.
+func (l *LinerReader) SetWordCompleter(f func(line string, pos int) (head string, completions []string, tail string)) {
+	l.State.SetWordCompleter(f)
+}
+
+// Terminal returns standard output, which liner draws on.
+func (l *LinerReader) Terminal() io.Writer {
+	return os.Stdout
+}
+
+// ReaderLineReader is a LineReader that reads newline separated input from an
+// io.Reader.  It does not support history or completion.
+type ReaderLineReader struct {
+	sc *bufio.Scanner
+	w  io.Writer
+}
+
+// NewReaderLineReader returns a LineReader that reads lines from r.  If w is
+// not nil, prompts are written to it before reading each line.
+func NewReaderLineReader(r io.Reader, w io.Writer) *ReaderLineReader {
+	return &ReaderLineReader{
+		sc: bufio.NewScanner(r),
+		w:  w,
+	}
+}
+
+// Prompt writes the prompt, if configured to, and returns the next line.
+func (r *ReaderLineReader) Prompt(prompt string) (string, error) {
+	if r.w != nil {
+		if _, err := io.WriteString(r.w, prompt); err != nil {
+			return "", err
+		}
+	}
+	if !r.sc.Scan() {
+		if err := r.sc.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return strings.TrimSuffix(r.sc.Text(), "\r"), nil
+}
+
+// Terminal returns the writer prompts are written to, or nil if there isn't
+// one.
+func (r *ReaderLineReader) Terminal() io.Writer {
+	return r.w
+}
+
+// AppendHistory does nothing, history is not supported.
+func (r *ReaderLineReader) AppendHistory(item string) {}
+
+// SetCompleter does nothing, completion is not supported.
+func (r *ReaderLineReader) SetCompleter(f func(line string) []string) {}
+
+// Close does nothing, the underlying reader is owned by the caller.
+func (r *ReaderLineReader) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package prompt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// the terminal reader completes the word at the cursor
+var _ wordCompleterSetter = &LinerReader{}
+
+// completions are listed where the readers draw the prompt
+var _ terminalWriter = &LinerReader{}
+var _ terminalWriter = &ReaderLineReader{}
+
+func TestReaderLineReader(t *testing.T) {
+	w := &bytes.Buffer{}
+	lr := NewReaderLineReader(strings.NewReader("foo\r\nbar baz\n\nlast"), w)
+	for _, exp := range []string{"foo", "bar baz", "", "last"} {
+		got, err := lr.Prompt("> ")
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if got != exp {
+			t.Errorf("expected %q, got %q", exp, got)
+		}
+	}
+	if _, err := lr.Prompt("> "); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if got := w.String(); got != "> > > > > " {
+		t.Errorf("expected prompts to be written, got %q", got)
+	}
+}
+
+func TestPromptWithReader(t *testing.T) {
+	lr := NewReaderLineReader(strings.NewReader("test a\ntest b\n"), nil)
+	p := NewPromptWithReader(lr)
+	defer p.Close()
+
+	got := []string{}
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("test $1", func(w io.Writer, args []string) {
+		got = append(got, args[0])
+	})
+
+	for p.Prompt() {
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected commands to run with [a b], got %v", got)
+	}
+}
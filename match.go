@@ -0,0 +1,297 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// matcher holds the state of aligning user input with a command description.
+type matcher struct {
+	words    []segment   // the user input
+	abbrev   bool        // allow keywords to be abbreviated
+	repeats  bool        // allow keyword-value options to be used more than once
+	resolved []string    // if set, the only keyword each abbreviated word may expand to
+	segs     []int       // the description segment matched by each word so far
+	scores   []matchType // how well each word so far matched
+}
+
+// binding is a complete alignment of user input with a command description.
+type binding struct {
+	words  []segment   // the user input, without flags
+	segs   []int       // the description segment matched by each word
+	scores []matchType // how well each word matched
+	flags  *flagSet    // the flags given
+}
+
+func (m *matcher) push(di int, mt matchType) {
+	m.segs = append(m.segs, di)
+	m.scores = append(m.scores, mt)
+}
+
+func (m *matcher) pop() {
+	m.segs = m.segs[:len(m.segs)-1]
+	m.scores = m.scores[:len(m.scores)-1]
+}
+
+// matchWord returns how well the word at wi matches seg.
+func (m *matcher) matchWord(wi int, seg segment) matchType {
+	mt := matchesSegment(m.words[wi], seg, m.abbrev)
+	if mt == matchPrefix && wi < len(m.resolved) && seg.keyword(m.resolved[wi]) != m.resolved[wi] {
+		return matchNone
+	}
+	return mt
+}
+
+// startsGroup returns true if the segment at di is the first of an optional
+// group.
+func (c *command) startsGroup(di int) bool {
+	opt := c.desc.words[di].opt
+	return opt != 0 && (di == 0 || c.desc.words[di-1].opt != opt)
+}
+
+// groupEnd returns the index of the first segment after the optional group
+// starting at di.
+func (c *command) groupEnd(di int) int {
+	opt := c.desc.words[di].opt
+	for di < len(c.desc.words) && c.desc.words[di].opt == opt {
+		di++
+	}
+	return di
+}
+
+// walk aligns the words of user input from wi onwards with the command
+// description from segment di onwards, calling visit with the position in
+// the description each time all of the words have been matched.  n is the
+// number of words already matched by a wildcard segment at di.
+func (c *command) walk(m *matcher, wi, di, n int, visit func(di, n int)) {
+	if wi == len(m.words) {
+		visit(di, n)
+		return
+	}
+	if di >= len(c.desc.words) {
+		return
+	}
+
+	seg := c.desc.words[di]
+	if seg.kv != 0 {
+		c.walkOptions(m, wi, di, n, visit)
+		return
+	}
+	if seg.isWildcard() {
+		if seg.max < 0 || n < seg.max {
+			m.push(di, matchWildcard)
+			c.walk(m, wi+1, di, n+1, visit)
+			m.pop()
+		}
+		// move on once enough words are matched
+		if n >= seg.min {
+			c.walk(m, wi, di+1, 0, visit)
+		}
+	} else if mt := m.matchWord(wi, seg); mt != matchNone {
+		m.push(di, mt)
+		c.walk(m, wi+1, di+1, 0, visit)
+		m.pop()
+	}
+
+	// the group can be skipped entirely
+	if n == 0 && c.startsGroup(di) {
+		c.walk(m, wi, c.groupEnd(di), 0, visit)
+	}
+}
+
+// walkOptions aligns the words from wi onwards with a group of keyword-value
+// options, each of which can be used once.  n is the set of options already
+// used, and di is either the start of the group or the value of the option
+// matched by the previous word.
+func (c *command) walkOptions(m *matcher, wi, di, n int, visit func(di, n int)) {
+	start := c.optionsStart(di)
+	if di != start {
+		m.push(di, matchSubstitution)
+		c.walk(m, wi+1, start, n, visit)
+		m.pop()
+		return
+	}
+	end := c.optionsEnd(start)
+	for k, kdi := 0, start; kdi < end; k, kdi = k+1, kdi+2 {
+		if n&(1<<uint(k)) != 0 && !m.repeats {
+			continue
+		}
+		if mt := m.matchWord(wi, c.desc.words[kdi]); mt != matchNone {
+			m.push(kdi, mt)
+			c.walk(m, wi+1, kdi+1, n|1<<uint(k), visit)
+			m.pop()
+		}
+	}
+	c.walk(m, wi, end, 0, visit)
+}
+
+// repeatedOption returns an error naming the first keyword-value option used
+// more than once in b, or nil if there isn't one.
+func (c *command) repeatedOption(b *binding) error {
+	used := map[int]bool{}
+	for _, di := range b.segs {
+		seg := c.desc.words[di]
+		if seg.kv == 0 || seg.typ != wordType {
+			continue
+		}
+		if used[di] {
+			return fmt.Errorf("option %s given twice", seg.value)
+		}
+		used[di] = true
+	}
+	return nil
+}
+
+// optionsStart returns the index of the first segment of the keyword-value
+// group containing di.
+func (c *command) optionsStart(di int) int {
+	kv := c.desc.words[di].kv
+	for di > 0 && c.desc.words[di-1].kv == kv {
+		di--
+	}
+	return di
+}
+
+// optionsEnd returns the index of the first segment after the keyword-value
+// group starting at di.
+func (c *command) optionsEnd(di int) int {
+	kv := c.desc.words[di].kv
+	for di < len(c.desc.words) && c.desc.words[di].kv == kv {
+		di++
+	}
+	return di
+}
+
+// canEnd returns true if the description can end at segment di, having
+// matched n words with a wildcard at di.
+func (c *command) canEnd(di, n int) bool {
+	if di >= len(c.desc.words) {
+		return true
+	}
+	if c.desc.words[di].kv != 0 {
+		// a keyword needs its value
+		return di == c.optionsStart(di) && c.canEnd(c.optionsEnd(di), 0)
+	}
+	if n == 0 && c.startsGroup(di) {
+		return c.canEnd(c.groupEnd(di), 0)
+	}
+	if seg := c.desc.words[di]; seg.isWildcard() && n >= seg.min {
+		return c.canEnd(di+1, 0)
+	}
+	return false
+}
+
+// frontier returns the indices of the segments that could match the next
+// word of user input, having matched n words with a wildcard at di.
+func (c *command) frontier(di, n int) []int {
+	if di >= len(c.desc.words) {
+		return nil
+	}
+	next := []int{}
+	if c.desc.words[di].kv != 0 {
+		start := c.optionsStart(di)
+		if di != start {
+			return []int{di}
+		}
+		end := c.optionsEnd(start)
+		for k, kdi := 0, start; kdi < end; k, kdi = k+1, kdi+2 {
+			if n&(1<<uint(k)) == 0 {
+				next = append(next, kdi)
+			}
+		}
+		return append(next, c.frontier(end, 0)...)
+	}
+	if n == 0 && c.startsGroup(di) {
+		next = append(next, c.frontier(c.groupEnd(di), 0)...)
+	}
+	if seg := c.desc.words[di]; seg.isWildcard() {
+		if n >= seg.min {
+			next = append(next, c.frontier(di+1, 0)...)
+		}
+		if seg.max >= 0 && n >= seg.max {
+			return next
+		}
+	}
+	return append(next, di)
+}
+
+// bind returns the best alignment of the words with the command description
+// or nil if they don't match.
+func (c *command) bind(m *matcher) *binding {
+	return c.align(m, true)
+}
+
+// bindPrefix returns the best alignment of the words with the start of the
+// command description or nil if they don't match.
+func (c *command) bindPrefix(m *matcher) *binding {
+	return c.align(m, false)
+}
+
+// align returns the best alignment of the words with the command
+// description, which must match all of it if whole is true.
+func (c *command) align(m *matcher, whole bool) *binding {
+	var best *binding
+	c.walk(m, 0, 0, 0, func(di, n int) {
+		if whole && !c.canEnd(di, n) {
+			return
+		}
+		if best == nil || compareScores(m.scores, best.scores) > 0 {
+			best = &binding{
+				words:  m.words,
+				segs:   append([]int(nil), m.segs...),
+				scores: append([]matchType(nil), m.scores...),
+			}
+		}
+	})
+	return best
+}
+
+// nextSegments returns the indices of the segments that could match the word
+// following words, and whether words are a complete command.
+func (c *command) nextSegments(m *matcher) (next []int, complete bool) {
+	seen := map[int]bool{}
+	c.walk(m, 0, 0, 0, func(di, n int) {
+		if c.canEnd(di, n) {
+			complete = true
+		}
+		for _, ni := range c.frontier(di, n) {
+			if !seen[ni] {
+				seen[ni] = true
+				next = append(next, ni)
+			}
+		}
+	})
+	return next, complete
+}
+
+// keywords returns the keywords that match the segment.
+func (s segment) keywords() []string {
+	switch s.typ {
+	case wordType:
+		return []string{s.value}
+	case alternationType:
+		return s.alts
+	}
+	return nil
+}
+
+// keyword returns the keyword of the segment that word is, or abbreviates,
+// or an empty string if there isn't one.  An exact match is preferred.
+func (s segment) keyword(word string) string {
+	kw := ""
+	for _, k := range s.keywords() {
+		if k == word {
+			return k
+		}
+		if kw == "" && word != "" && strings.HasPrefix(k, word) {
+			kw = k
+		}
+	}
+	return kw
+}
+
+// isWildcard returns true if the segment is a variadic placeholder, e.g. $*
+// or $+.
+func (s segment) isWildcard() bool {
+	return s.typ == placeholderType && s.value == "*"
+}
@@ -4,9 +4,9 @@ package prompt
 
 import "fmt"
 
-const _matchType_name = "matchNonematchWildcardmatchSubstitutionmatchExact"
+const _matchType_name = "matchNonematchWildcardmatchSubstitutionmatchPrefixmatchExact"
 
-var _matchType_index = [...]uint8{0, 9, 22, 39, 49}
+var _matchType_index = [...]uint8{0, 9, 22, 39, 50, 60}
 
 func (i matchType) String() string {
 	if i >= matchType(len(_matchType_index)-1) {
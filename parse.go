@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -53,12 +54,18 @@ type segmentType byte
 const (
 	wordType segmentType = iota
 	placeholderType
+	alternationType
 )
 
 type segment struct {
 	value string
 	typ   segmentType
-	ctype string // completion type
+	ctype string   // completion type
+	opt   int      // the optional group the segment belongs to, 0 if required
+	alts  []string // the keywords of an alternation
+	kv    int      // the keyword-value group the segment belongs to, 0 if none
+	min   int      // the fewest words a variadic placeholder matches
+	max   int      // the most words a variadic placeholder matches, -1 if unlimited
 }
 
 type filter struct {
@@ -76,6 +83,17 @@ func (s segment) String() string {
 		b.WriteString(" complete:")
 		b.WriteString(s.ctype)
 	}
+	if s.opt != 0 {
+		b.WriteString(" optional:")
+		b.WriteString(strconv.Itoa(s.opt))
+	}
+	if s.kv != 0 {
+		b.WriteString(" options:")
+		b.WriteString(strconv.Itoa(s.kv))
+	}
+	if s.isWildcard() && (s.min != 0 || s.max != -1) {
+		fmt.Fprintf(&b, " count:%d,%d", s.min, s.max)
+	}
 	b.WriteRune('>')
 	return b.String()
 }
@@ -112,6 +130,9 @@ type parser struct {
 	curInput   input
 	parsed     []input
 	err        error
+	optGroup   int // the currently open optional group, 0 if none
+	optGroups  int // the number of optional groups in the current command
+	kvGroups   int // the number of keyword-value groups in the current command
 }
 
 func (p *parser) next() item {
@@ -120,7 +141,7 @@ func (p *parser) next() item {
 		p.items = p.items[1:]
 		return r
 	}
-	return <-p.lexedItems
+	return p.lexNext()
 }
 
 func (p *parser) peek() item {
@@ -128,10 +149,20 @@ func (p *parser) peek() item {
 		return p.items[0]
 	}
 
-	p.items = append(p.items, <-p.lexedItems)
+	p.items = append(p.items, p.lexNext())
 	return p.items[0]
 }
 
+// lexNext returns the next item from the lexer, skipping line continuations
+// which only join lines.
+func (p *parser) lexNext() item {
+	for {
+		if i := <-p.lexedItems; i.typ != itemLineCont {
+			return i
+		}
+	}
+}
+
 func (p *parser) backup(i item) {
 	p.items = append(p.items, i)
 }
@@ -148,7 +179,7 @@ func parseOutputfile(p *parser) parseStateFn {
 		p.err = errors.New("cannot specify multiple output files")
 		return nil
 	}
-	p.curInput.outputFile = file.val
+	p.curInput.outputFile = unquote(file.val)
 
 	nItem := p.next()
 	switch nItem.typ {
@@ -166,14 +197,14 @@ func parseOutputfile(p *parser) parseStateFn {
 
 func parseFilter(p *parser) parseStateFn {
 	filterCmd := p.next()
-	if filterCmd.typ != itemWord {
+	if filterCmd.typ != itemWord && filterCmd.typ != itemQuotedString {
 		p.err = errors.New("expected word after |")
 		return nil
 	}
 	f := filter{}
-	f.cmd = filterCmd.val
-	for fa := p.peek(); fa.typ == itemWord; {
-		f.args = append(f.args, fa.val)
+	f.cmd = unquote(filterCmd.val)
+	for fa := p.peek(); fa.typ == itemWord || fa.typ == itemQuotedString; {
+		f.args = append(f.args, unquote(fa.val))
 		p.next()
 		fa = p.peek()
 	}
@@ -184,7 +215,13 @@ func parseFilter(p *parser) parseStateFn {
 
 func parsePlaceholder(p *parser) parseStateFn {
 	item := p.next()
-	seg := segment{item.val, placeholderType, ""}
+	seg := segment{value: item.val, typ: placeholderType, opt: p.optGroup}
+	if strings.HasPrefix(item.val, "*") || strings.HasPrefix(item.val, "+") {
+		if err := parseVariadic(&seg, item.val); err != nil {
+			p.err = err
+			return nil
+		}
+	}
 	if p.peek().typ == itemCompletionType {
 		seg.ctype = p.next().val
 	}
@@ -199,13 +236,167 @@ func parsePlaceholder(p *parser) parseStateFn {
 	return parseMidCmd
 }
 
+// parseVariadic sets the number of words a variadic placeholder matches from
+// its text, e.g. "*" for any number, "+" for at least one or "*{1,3}" for
+// one to three.
+func parseVariadic(seg *segment, text string) error {
+	seg.value, seg.min, seg.max = "*", 0, -1
+	if text[0] == '+' {
+		seg.min = 1
+	}
+	if len(text) == 1 {
+		return nil
+	}
+	if text[0] != '*' {
+		return fmt.Errorf("invalid placeholder $%s", text)
+	}
+
+	bounds := strings.Split(strings.TrimSuffix(text[2:], "}"), ",")
+	var err error
+	if seg.min, err = strconv.Atoi(bounds[0]); err != nil || len(bounds) > 2 {
+		return fmt.Errorf("invalid placeholder $%s", text)
+	}
+	switch {
+	case len(bounds) == 1:
+		seg.max = seg.min
+	case bounds[1] != "":
+		if seg.max, err = strconv.Atoi(bounds[1]); err != nil {
+			return fmt.Errorf("invalid placeholder $%s", text)
+		}
+	}
+	if seg.max == 0 || (seg.max > 0 && seg.max < seg.min) {
+		return fmt.Errorf("invalid placeholder $%s", text)
+	}
+	return nil
+}
+
+// parseAlternation parses a group of alternative keywords, e.g. {on|off}, or
+// a group of keyword-value options, e.g. {count $1 | size $2}*
+func parseAlternation(p *parser) parseStateFn {
+	options := [][]segment{}
+	for {
+		kw := p.next()
+		switch kw.typ {
+		case itemWord:
+		case itemError:
+			p.err = errors.New(kw.val)
+			return nil
+		default:
+			p.err = errors.New("expected keyword in alternation")
+			return nil
+		}
+		option := []segment{{value: kw.val, typ: wordType}}
+		if p.peek().typ == itemPlaceholder {
+			seg := segment{value: p.next().val, typ: placeholderType}
+			if p.peek().typ == itemCompletionType {
+				seg.ctype = p.next().val
+			}
+			option = append(option, seg)
+		}
+		options = append(options, option)
+
+		switch sep := p.next(); sep.typ {
+		case itemAltSep:
+		case itemRBrace:
+			if sep.val == "}*" {
+				return p.addOptions(options)
+			}
+			return p.addAlternation(options)
+		case itemEOF, itemChanClose:
+			p.err = errors.New("unterminated alternation")
+			return nil
+		case itemError:
+			p.err = errors.New(sep.val)
+			return nil
+		default:
+			p.err = fmt.Errorf("unexpected %s token '%s' in alternation", sep.typ, sep.val)
+			return nil
+		}
+	}
+}
+
+// addAlternation adds an alternation of the keywords in options.
+func (p *parser) addAlternation(options [][]segment) parseStateFn {
+	seg := segment{typ: alternationType, opt: p.optGroup}
+	for _, option := range options {
+		if len(option) != 1 {
+			p.err = errors.New("expected keyword in alternation")
+			return nil
+		}
+		for _, a := range seg.alts {
+			if a == option[0].value {
+				p.err = fmt.Errorf("duplicate keyword %s in alternation", a)
+				return nil
+			}
+		}
+		seg.alts = append(seg.alts, option[0].value)
+	}
+	if len(seg.alts) < 2 {
+		p.err = errors.New("alternation needs at least two keywords")
+		return nil
+	}
+	seg.value = strings.Join(seg.alts, "|")
+	p.curInput.words = append(p.curInput.words, seg)
+	return parseMidCmd
+}
+
+// addOptions adds a group of keyword-value options, which can be given in any
+// order.
+func (p *parser) addOptions(options [][]segment) parseStateFn {
+	if p.optGroup != 0 {
+		p.err = errors.New("keyword-value options are already optional")
+		return nil
+	}
+	p.kvGroups++
+	keywords := map[string]bool{}
+	for _, option := range options {
+		if len(option) != 2 || option[1].value == "*" {
+			p.err = errors.New("expected keyword and placeholder in options")
+			return nil
+		}
+		if keywords[option[0].value] {
+			p.err = fmt.Errorf("duplicate keyword %s in options", option[0].value)
+			return nil
+		}
+		keywords[option[0].value] = true
+		for _, ow := range p.curInput.words {
+			if ow.typ == placeholderType && ow.value == option[1].value {
+				p.err = fmt.Errorf("duplicate placeholder $%s", ow.value)
+				return nil
+			}
+		}
+		for _, seg := range option {
+			seg.kv = p.kvGroups
+			p.curInput.words = append(p.curInput.words, seg)
+		}
+	}
+	return parseMidCmd
+}
+
+// parseOptional starts an optional group of segments
+func parseOptional(p *parser) parseStateFn {
+	if p.optGroup != 0 {
+		p.err = errors.New("optional segments can't be nested")
+		return nil
+	}
+	p.optGroups++
+	p.optGroup = p.optGroups
+	return parseMidCmd
+}
+
 // starting a new commmand
 func parseStartCmd(p *parser) parseStateFn {
+	if p.optGroup != 0 {
+		p.err = errors.New("unterminated optional segment")
+		return nil
+	}
 	if len(p.curInput.words) > 0 {
 		p.parsed = append(p.parsed, p.curInput)
 	}
 
 	p.curInput = input{}
+	p.optGroups = 0
+	p.kvGroups = 0
 	for {
 		item := p.next()
 		switch item.typ {
@@ -213,7 +404,7 @@ func parseStartCmd(p *parser) parseStateFn {
 			fallthrough
 		case itemChanClose:
 			return nil
-		case itemWord:
+		case itemWord, itemQuotedString, itemLBracket, itemLBrace:
 			p.backup(item)
 			return parseMidCmd
 		default:
@@ -235,8 +426,29 @@ func parseMidCmd(p *parser) parseStateFn {
 		case itemError:
 			p.err = errors.New(item.val)
 			return nil
-		case itemWord:
-			p.curInput.words = append(p.curInput.words, segment{item.val, wordType, ""})
+		case itemWord, itemQuotedString:
+			p.curInput.words = append(p.curInput.words, segment{value: unquote(item.val), typ: wordType, opt: p.optGroup})
+		// [
+		case itemLBracket:
+			return parseOptional
+		// ]
+		case itemRBracket:
+			if p.optGroup == 0 {
+				p.err = errors.New("unexpected ]")
+				return nil
+			}
+			if last := len(p.curInput.words) - 1; last < 0 || p.curInput.words[last].opt != p.optGroup {
+				p.err = errors.New("empty optional segment")
+				return nil
+			}
+			p.optGroup = 0
+		// {
+		case itemLBrace:
+			return parseAlternation
+		// }
+		case itemRBrace:
+			p.err = errors.New("unexpected }")
+			return nil
 		// $*, $1, $2, etc.
 		case itemPlaceholder:
 			p.backup(item)
@@ -271,6 +483,9 @@ func (p *parser) run() ([]input, error) {
 	for state := parseStartCmd; state != nil; {
 		state = state(p)
 	}
+	if p.err == nil && p.optGroup != 0 {
+		p.err = errors.New("unterminated optional segment")
+	}
 	if len(p.curInput.words) > 0 {
 		p.parsed = append(p.parsed, p.curInput)
 	}
@@ -279,3 +494,35 @@ func (p *parser) run() ([]input, error) {
 	}
 	return p.parsed, nil
 }
+
+// unquote returns a word of user input with its quotes removed and escapes
+// processed.  A backslash escapes any character outside of quotes, and only
+// " and \ in a double quoted string.  Single quoted and backquoted strings
+// are taken literally.
+func unquote(word string) string {
+	b := bytes.Buffer{}
+	var quote rune
+	escaped := false
+	for _, r := range word {
+		switch {
+		case escaped:
+			if quote == '"' && r != '"' && r != '\\' {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\' && (quote == 0 || quote == '"'):
+			escaped = true
+		case quote == 0 && isQuote(r):
+			quote = r
+		case r == quote:
+			quote = 0
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		b.WriteRune('\\')
+	}
+	return b.String()
+}
@@ -15,8 +15,39 @@ func TestParse(t *testing.T) {
 		{" foo; bar ; baz ", "[{<wordType foo>} {<wordType bar>} {<wordType baz>}]", ""},
 		{"foo $*", "[{<wordType foo> <placeholderType *>}]", ""},
 		{"foo $* $*", "[]", "duplicate placeholder $*"},
+		{"foo $+", "[{<wordType foo> <placeholderType * count:1,-1>}]", ""},
+		{"foo $*{1,3}:host", "[{<wordType foo> <placeholderType * complete:host count:1,3>}]", ""},
+		{"foo $*{2}", "[{<wordType foo> <placeholderType * count:2,2>}]", ""},
+		{"foo $*{2,}", "[{<wordType foo> <placeholderType * count:2,-1>}]", ""},
+		{"foo $+ $*", "[]", "duplicate placeholder $*"},
+		{"foo $*{3,1}", "[]", "invalid placeholder $*{3,1}"},
+		{"foo $*{0}", "[]", "invalid placeholder $*{0}"},
+		{"foo $*{,2}", "[]", "invalid placeholder $*{,2}"},
+		{"foo $+{1,2}", "[]", "invalid placeholder $+{1,2}"},
+		{"foo $*{a}", "[]", "invalid placeholder count"},
 		{"foo $2 $1", "[{<wordType foo> <placeholderType 2> <placeholderType 1>}]", ""},
 		{"foo $2 $2", "[]", "duplicate placeholder $2"},
+		{"ping $host:hostname $n", "[{<wordType ping> <placeholderType host complete:hostname> <placeholderType n>}]", ""},
+		{"ping $host $host", "[]", "duplicate placeholder $host"},
+		{"foo [bar $1] baz", "[{<wordType foo> <wordType bar optional:1> <placeholderType 1 optional:1> <wordType baz>}]", ""},
+		{"foo [bar] [$1]", "[{<wordType foo> <wordType bar optional:1> <placeholderType 1 optional:2>}]", ""},
+		{"foo [bar", "[]", "unterminated optional segment"},
+		{"foo bar]", "[]", "unexpected ]"},
+		{"foo []", "[]", "empty optional segment"},
+		{"foo [bar [baz]]", "[]", "optional segments can't be nested"},
+		{"log {on|off} [{a|b}]", "[{<wordType log> <alternationType on|off> <alternationType a|b optional:1>}]", ""},
+		{"log {on|off", "[]", "unterminated alternation"},
+		{"log {on}", "[]", "alternation needs at least two keywords"},
+		{"log {on|on}", "[]", "duplicate keyword on in alternation"},
+		{"log {on|$1}", "[]", "expected keyword in alternation"},
+		{"log on}", "[]", "unexpected }"},
+		{"ping {count $c:int | size $s}*", "[{<wordType ping> <wordType count options:1> <placeholderType c complete:int options:1> " +
+			"<wordType size options:1> <placeholderType s options:1>}]", ""},
+		{"ping {count $c | size}*", "[]", "expected keyword and placeholder in options"},
+		{"ping {count $c | count $d}*", "[]", "duplicate keyword count in options"},
+		{"ping $c {count $c}*", "[]", "duplicate placeholder $c"},
+		{"ping [{count $c}*]", "[]", "keyword-value options are already optional"},
+		{"log {on $1|off}", "[]", "expected keyword in alternation"},
 		{"foo $*:host|grep -i 192 | grep -v 100>a.txt; ls a.txt",
 			"[{<wordType foo> <placeholderType * complete:host> filter: grep[-i 192] grep[-v 100]} {<wordType ls> <wordType a.txt>}]", ""}}
 
@@ -42,6 +73,44 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseUserInput(t *testing.T) {
+	tests := []struct {
+		input  string
+		words  []string
+		output string
+	}{
+		{`echo "hello world"`, []string{"echo", "hello world"}, ""},
+		{`echo 'it''s' "a \"b\" \c"`, []string{"echo", "its", `a "b" \c`}, ""},
+		{`echo 'a \n' a\ b\\`, []string{"echo", `a \n`, `a b\`}, ""},
+		{"echo `a $b`", []string{"echo", "a $b"}, ""},
+		{`echo pre"fix suf"fix`, []string{"echo", "prefix suffix"}, ""},
+		{`"show" version > "my file.txt"`, []string{"show", "version"}, "my file.txt"},
+		{`show > my\ file`, []string{"show"}, "my file"},
+		{"echo a \\\n b\\\n", []string{"echo", "a", "b"}, ""},
+		{"echo \"a\nb\"", []string{"echo", "a\nb"}, ""},
+		{"set {\n a 1\n}", []string{"set", "{", "a", "1", "}"}, ""},
+	}
+	for _, tc := range tests {
+		inp, err := parseUserInput(tc.input)
+		if err != nil {
+			t.Errorf("expected no error, got %s for %s", err, tc.input)
+			continue
+		}
+		words := []string{}
+		for _, w := range inp[0].words {
+			words = append(words, w.value)
+		}
+		if fmt.Sprintf("%q", words) != fmt.Sprintf("%q", tc.words) || inp[0].outputFile != tc.output {
+			t.Errorf("expected %q > %q, got %q > %q for %s", tc.words, tc.output, words, inp[0].outputFile, tc.input)
+		}
+	}
+
+	inp, err := parseUserInput(`show | grep "a b" 'c'`)
+	if err != nil || fmt.Sprintf("%q", inp[0].filters[0].args) != `["a b" "c"]` {
+		t.Errorf("expected quoted filter arguments, got %v (%v)", inp, err)
+	}
+}
+
 func TestArgExtraction(t *testing.T) {
 	tests := []struct {
 		cmd   string
@@ -50,17 +119,45 @@ func TestArgExtraction(t *testing.T) {
 	}{
 		{"go $*", "go foo", []string{"foo"}},
 		{"go $*", "go foo bar baz", []string{"foo", "bar", "baz"}},
+		{"go $*", "go", []string{}},
+		{"copy $+ to $1", "copy a b to c", []string{"c", "a", "b"}},
+		{"copy $*{1,2} to $1", "copy a to to to", []string{"to", "a", "to"}},
 		{"go $1", "go foo", []string{"foo"}},
 		{"go $1 $2", "go foo bar", []string{"foo", "bar"}},
 		{"go $2 $1", "go foo bar", []string{"bar", "foo"}},
 		{"go $3 $1 $2", "go a b c", []string{"b", "c", "a"}},
+		{"go [$1]", "go", []string{""}},
+		{"go [$1]", "go foo", []string{"foo"}},
+		{"show [detail] $1", "show eth0", []string{"", "eth0"}},
+		{"show [detail] $1", "show detail eth0", []string{"detail", "eth0"}},
+		{"show [ip] [$1]", "show ip", []string{"ip", ""}},
+		{"go [$2] $1 $*", "go a b c", []string{"b", "a", "c"}},
+		{"go [$2] $1", "go a", []string{"a", ""}},
+		{"show [ip] route [$1] [detail $2]", "show route 1", []string{"", "1", ""}},
+		{"show [ip] route [$1] [detail $2]", "show route detail 2", []string{"", "", "2"}},
+		{"log {on|off}", "log off", []string{"off"}},
+		{"log [{on|off}] $1", "log x", []string{"", "x"}},
+		{"ping $host [$count]", "ping a 5", []string{"a", "5"}},
+		{"ping $h {count $c | size $s}*", "ping a size 2", []string{"a"}},
+		{"ping $h {count $c | size $s}* $1", "ping a size 2 count 1 b", []string{"a", "b"}},
+		{"ping $1 {count $2 | size $3}* $4", "ping a size 2 b", []string{"a", "b"}},
 	}
 
 	for _, tc := range tests {
 		inp, _ := parseUserInput(tc.input)
-		cmd, _ := parseCmdDescription(tc.cmd)
+		cmd, _ := parseCommand(tc.cmd, nil)
 
-		res := extractArgs(inp[0].words, cmd[0].words)
+		b := cmd.bind(&matcher{words: inp[0].words})
+		if b == nil {
+			t.Errorf("expected %q to match %q", tc.input, tc.cmd)
+			continue
+		}
+		a, err := cmd.args(b, nil)
+		if err != nil {
+			t.Errorf("expected no error, got %s", err)
+			continue
+		}
+		res := a.Strings()
 		if len(res) != len(tc.args) {
 			t.Errorf("expected %s = %s", res, tc.args)
 		}
@@ -2,48 +2,100 @@ package prompt
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"sort"
-	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
 
 	"github.com/peterh/liner"
 )
 
 // Prompt is the user prompt.
 type Prompt struct {
-	LineState   *liner.State           // the liner used for input, visibile to allow direct manipulation/changes
-	Prompter    func() string          // Prompt is the function called to return the prompt
-	curPrompt   string                 // the current prompt passed to liner
-	commandSets map[string]*CommandSet // registered command sets
-	completers  map[string]Completer   // context-sensitive placeholder completion
-	filters     map[string]Filter      // filtering of command output
-	cmdSetStack []*CommandSet          // stack of command sets that have been pushed
+	LineReader     LineReader                    // the source of user input
+	LineState      *liner.State                  // the liner used for input if reading from the terminal, visibile to allow direct manipulation/changes
+	Prompter       func() string                 // Prompt is the function called to return the prompt
+	ContinuePrompt string                        // the prompt shown when reading further lines of an incomplete command
+	Stdout         io.Writer                     // command and filter output is written here
+	Stderr         io.Writer                     // error messages are written here
+	OnError        ErrorHandler                  // called to report errors, writing to Stderr
+	Abbreviate     bool                          // allow command keywords to be abbreviated to any unique prefix
+	lastErr        error                         // the result of the last command run
+	curPrompt      string                        // the current prompt passed to liner
+	commandSets    map[string]*CommandSet        // registered command sets
+	completers     map[string]DescribedCompleter // context-sensitive placeholder completion
+	completerOpts  map[string]CompleterOptions   // timeouts and caching of completers
+	completions    completionCache               // cached completions
+	types          map[string]ArgType            // placeholder validation
+	defaultTypes   map[string]bool               // built-in types that haven't been replaced
+	defaultComps   map[string]bool               // completers of built-in types that haven't been replaced
+	filters        map[string]FilterDef          // filtering of command output
+	cmdSetStack    []Mode                        // stack of command sets that have been pushed
+	builtins       *CommandSet                   // commands available in every command set
+	suggestion     string                        // input to prefill the next prompt with
+	sourcing       map[string]bool               // the files being run by source, to stop a file sourcing itself
 }
 
-// NewPrompt returns a newly initialized prompt.
+// Mode is an entry on the command set stack.
+type Mode struct {
+	CommandSet *CommandSet
+	Context    interface{} // the context the command set was entered with
+}
+
+// NewPrompt returns a newly initialized prompt that reads from the terminal.
 func NewPrompt() *Prompt {
-	line := liner.NewLiner()
-	line.SetCtrlCAborts(true)
-	line.SetTabCompletionStyle(liner.TabPrints)
+	return NewPromptWithReader(NewLinerReader())
+}
+
+// NewPromptWithReader returns a newly initialized prompt that reads user input
+// from lr.
+func NewPromptWithReader(lr LineReader) *Prompt {
 	p := &Prompt{
-		Prompter: func() string {
-			return "> "
-		},
-		LineState:   line,
-		completers:  map[string]Completer{},
-		filters:     map[string]Filter{},
-		commandSets: map[string]*CommandSet{},
+		LineReader:     lr,
+		Stdout:         os.Stdout,
+		Stderr:         os.Stderr,
+		OnError:        DefaultErrorHandler,
+		ContinuePrompt: "> ",
+		completers:     map[string]DescribedCompleter{},
+		completerOpts:  map[string]CompleterOptions{},
+		completions:    completionCache{},
+		types:          map[string]ArgType{},
+		defaultTypes:   map[string]bool{},
+		defaultComps:   map[string]bool{},
+		filters:        map[string]FilterDef{},
+		commandSets:    map[string]*CommandSet{},
+		sourcing:       map[string]bool{},
+	}
+	p.Prompter = p.ModePrompt
+	for name, t := range builtinTypes() {
+		p.RegisterType(name, t)
+		p.defaultTypes[name] = true
+		if t.Completer != nil {
+			p.defaultComps[name] = true
+		}
+	}
+	p.builtins = p.newBuiltins()
+	if l, ok := lr.(*LinerReader); ok {
+		p.LineState = l.State
+	}
+	if wc, ok := lr.(wordCompleterSetter); ok {
+		wc.SetWordCompleter(p.wordCompleter)
+	} else {
+		lr.SetCompleter(p.tabCompleter)
 	}
-	line.SetCompleter(p.inputCompleter)
 	return p
 }
 
 // Close closes and cleans up the prompt.
 func (p *Prompt) Close() error {
-	err := p.LineState.Close()
+	err := p.LineReader.Close()
+	p.LineReader = nil
 	p.LineState = nil
 	return err
 }
@@ -53,106 +105,87 @@ func (p *Prompt) NewCommandSet(name string) *CommandSet {
 	cs := newCommandSet(name)
 	p.commandSets[name] = cs
 	if p.cmdSetStack == nil {
-		p.cmdSetStack = append(p.cmdSetStack, cs)
+		p.cmdSetStack = append(p.cmdSetStack, Mode{CommandSet: cs})
 	}
 	return cs
 }
 
-// extractArgs takes user input and a command description and returns the input
-// arguments parsed against the command description, including reordering if
-// necessary.
-func extractArgs(input, cmd []segment) []string {
-	args := []string{}
-	indices := []int{}
-	for i := range input {
-		cw := cmd[i]
-		if cw.typ == placeholderType {
-			if cw.value == "*" {
-				// a $* consumes the rest of the arugments, so copy the remainder
-				for _, w := range input[i:] {
-					args = append(args, w.value)
-				}
-				break
-			} else {
-				args = append(args, input[i].value)
-				idx, _ := strconv.Atoi(cw.value)
-				indices = append(indices, idx)
-			}
-		}
-	}
-	// sort our argument list by the placeholder indices
-	sort.Sort(argSort{indices, args})
-	return args
-}
-
-// argSort is used for sorting input arguments by placeholder indices
-type argSort struct {
-	indices []int
-	args    []string
-}
-
-func (s argSort) Len() int           { return len(s.indices) }
-func (s argSort) Less(i, j int) bool { return s.indices[i] < s.indices[j] }
-func (s argSort) Swap(i, j int) {
-	s.indices[i], s.indices[j] = s.indices[j], s.indices[i]
-	s.args[i], s.args[j] = s.args[j], s.args[i]
-}
-
-func (p *Prompt) runCommand(match *command, input input) {
-	var out io.Writer = os.Stdout
-	close := []io.Closer{}
+func (p *Prompt) runCommand(match *command, args *Args, input input, out io.Writer) error {
 	// redirecting to a file?
 	if input.outputFile != "" {
 		f, err := os.Create(input.outputFile)
 		if err != nil {
-			fmt.Printf("error writing: %s\n", err)
+			return fmt.Errorf("error writing: %s", err)
 		}
+		defer f.Close()
 		out = f
-		close = append(close, f)
 	}
 
-	// apply filters to output
+	// apply filters to output, each filter reads from a pipe written to by
+	// the command or the filter before it
+	pipes := []*io.PipeWriter{}
+	done := []chan struct{}{}
 	for i := range input.filters {
 		filter := input.filters[len(input.filters)-i-1]
-		if fc, ok := p.filters[filter.cmd]; !ok {
-			fmt.Printf("%s is not a valid filter\n", filter.cmd)
-			return
-		} else {
-			pr, pw := io.Pipe()
-			close = append(close, pr)
-			go fc(pr, out, filter.args)
-			out = pw
+		fc, ok := p.filters[filter.cmd]
+		if !ok {
+			closePipes(pipes, done)
+			return fmt.Errorf("%s is not a valid filter", filter.cmd)
+		}
+		fargs, err := fc.filterArgs(filter.args, p.types)
+		if err != nil {
+			closePipes(pipes, done)
+			return fmt.Errorf("%s: %s", filter.cmd, err)
 		}
+		pr, pw := io.Pipe()
+		fin := make(chan struct{})
+		go func(w io.Writer, args *Args) {
+			fc.Run(pr, w, args)
+			// discard anything the filter didn't read so writers don't block
+			io.Copy(ioutil.Discard, pr)
+			close(fin)
+		}(out, fargs)
+		pipes = append(pipes, pw)
+		done = append(done, fin)
+		out = pw
 	}
-	match.execute(out, extractArgs(input.words, match.desc.words))
-	for _, c := range close {
-		c.Close()
+	err := match.execute(out, args)
+	closePipes(pipes, done)
+	return err
+}
+
+// closePipes closes the filter pipes starting with the one closest to the
+// command, waiting for each filter to finish before closing the next.
+func closePipes(pipes []*io.PipeWriter, done []chan struct{}) {
+	for i := len(pipes) - 1; i >= 0; i-- {
+		pipes[i].Close()
+		<-done[i]
 	}
 }
 
 // Prompt prompts the user and returns input.
 func (p *Prompt) Prompt() bool {
-	if userInput, err := p.LineState.Prompt(p.Prompter()); err == nil {
+	p.curPrompt = p.Prompter()
+	userInput, err := p.readLine()
+	if err == ErrUnterminatedCommand {
+		p.reportError(&CommandError{userInput, err})
+	}
+	if err == nil {
 		// user just hit enter with no input
 		if len(userInput) == 0 {
 			return true
 		}
 
-		parsed, err := parseUserInput(userInput)
-		if err != nil {
-			fmt.Printf("parse error: %s\n", err)
-			return true
+		if p.hasContextHelp(userInput) && !strings.Contains(userInput, "\n") {
+			// let the user continue typing where they left off
+			p.suggestion = strings.TrimSuffix(userInput, "?")
+		} else {
+			p.LineReader.AppendHistory(historyEntry(userInput))
 		}
-
-		for _, input := range parsed {
-			match := p.execMatch(input)
-			if match != nil {
-				p.runCommand(match, input)
-				p.LineState.AppendHistory(input.asUser())
-			} else {
-				fmt.Printf("%s: command not found\n", input.asUser())
-				return true
-			}
+		if err := p.runLine(context.Background(), userInput, p.Stdout); err != nil {
+			p.reportError(err)
+		} else {
+			p.lastErr = nil
 		}
 		return true
 	}
@@ -160,20 +193,186 @@ func (p *Prompt) Prompt() bool {
 	return false
 }
 
+// readLine reads a command from the line reader, prefilling it with the
+// suggested input if the reader supports it.  Further lines are read while
+// the command is incomplete, i.e. it ends with a backslash or has an
+// unterminated quoted string or an unclosed brace block, and joined with
+// newlines.  ErrUnterminatedCommand is returned, with the lines read, if the
+// input ends first.
+func (p *Prompt) readLine() (string, error) {
+	suggestion := p.suggestion
+	p.suggestion = ""
+	var line string
+	var err error
+	if s, ok := p.LineReader.(suggester); ok && suggestion != "" {
+		line, err = s.PromptWithSuggestion(p.curPrompt, suggestion, -1)
+	} else {
+		line, err = p.LineReader.Prompt(p.curPrompt)
+	}
+	if err != nil {
+		return "", err
+	}
+	for state := (lineState{}).next(line); state.incomplete(); {
+		more, err := p.LineReader.Prompt(p.ContinuePrompt)
+		if err == io.EOF {
+			return line, ErrUnterminatedCommand
+		}
+		if err != nil {
+			return "", err
+		}
+		line += "\n" + more
+		state = state.next(more)
+	}
+	return line, nil
+}
+
+// historyEntry returns user input read by readLine as a single line, as
+// history is saved a line per entry.  Line continuations are removed and the
+// lines joined with spaces.  A newline within quotes can't be kept on a
+// single line, so it's replaced with a space too.
+func historyEntry(input string) string {
+	lines := strings.Split(input, "\n")
+	entry := lines[0]
+	state := (lineState{}).next(lines[0])
+	for _, line := range lines[1:] {
+		if state.cont {
+			entry = strings.TrimRight(strings.TrimSuffix(entry, "\\"), " \t")
+		}
+		entry += " " + line
+		state = state.next(line)
+	}
+	return entry
+}
+
+// Execute runs a line of input as if the user had entered it and returns the
+// command output.  Filters and output redirection are applied as usual.  If
+// the line contains multiple commands, they are run in order until one fails
+// or ctx is done.
+func (p *Prompt) Execute(ctx context.Context, line string) (string, error) {
+	b := &bytes.Buffer{}
+	err := p.runLine(ctx, line, b)
+	return b.String(), err
+}
+
+// runLine parses and runs the commands in line, writing their output to out.
+func (p *Prompt) runLine(ctx context.Context, line string, out io.Writer) error {
+	if p.hasContextHelp(line) {
+		return p.contextHelp(line, out)
+	}
+
+	parsed, err := parseUserInput(line)
+	if err != nil {
+		return fmt.Errorf("parse error: %s", err)
+	}
+
+	for _, input := range parsed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		match, b, err := p.execMatch(input)
+		var args *Args
+		if err == nil {
+			args, err = match.args(b, p.types)
+		}
+		if args != nil {
+			args.ctx = ctx
+		}
+		if err != nil {
+			return &CommandError{input.asUser(), err}
+		}
+		if err := p.runCommand(match, args, input, out); err != nil {
+			return &CommandError{input.asUser(), err}
+		}
+	}
+	return nil
+}
+
+// reportError records err as the last error and passes it to the error
+// handler.
+func (p *Prompt) reportError(err error) {
+	p.lastErr = err
+	if p.OnError != nil {
+		p.OnError(p.Stderr, err)
+	}
+}
+
+// LastError returns the error from the last line of user input that was run,
+// or nil if it succeeded.
+func (p *Prompt) LastError() error {
+	return p.lastErr
+}
+
 // RegisterCompleter registers a function to be used for context sensitive
 // completion of command placeholders.
 func (p *Prompt) RegisterCompleter(name string, fn Completer) error {
-	if _, ok := p.completers[name]; ok {
+	return p.RegisterContextCompleter(name, fn.withContext())
+}
+
+// RegisterContextCompleter registers a function to be used for completion of
+// command placeholders that needs the command and the arguments typed before
+// the one being completed.  Completers registered with RegisterCompleter and
+// RegisterContextCompleter share the same names.
+func (p *Prompt) RegisterContextCompleter(name string, fn ContextCompleter) error {
+	return p.RegisterDescribedCompleter(name, fn.described())
+}
+
+// RegisterDescribedCompleter registers a function to be used for completion
+// of command placeholders that describes each completion, e.g. with the
+// meaning of a value.  The descriptions are shown when several completions
+// are listed.  The completer of a built-in type, e.g. "bool", can be replaced.
+func (p *Prompt) RegisterDescribedCompleter(name string, fn DescribedCompleter) error {
+	if _, ok := p.completers[name]; ok && !p.defaultComps[name] {
 		return fmt.Errorf("%s is already registered", name)
 	}
+	delete(p.defaultComps, name)
 	p.completers[name] = fn
 	return nil
 }
 
-// PopCommandSet removes the latest command set pushed.
+// SetCompleterOptions sets the timeout and caching of a registered completer.
+// The completions of a slow completer can be cached, and it can be given a
+// timeout so the terminal doesn't wait for it.
+func (p *Prompt) SetCompleterOptions(name string, opts CompleterOptions) error {
+	if _, ok := p.completers[name]; !ok {
+		return fmt.Errorf("%s is not registered", name)
+	}
+	p.completerOpts[name] = opts
+	return nil
+}
+
+// RegisterType registers a placeholder type, e.g. "vlan" for "$1:vlan".
+// Arguments for the placeholder are checked by the type's validator before
+// the command runs, and completed by its completer.  A built-in type, e.g.
+// "int", can be replaced.
+func (p *Prompt) RegisterType(name string, t ArgType) error {
+	if _, ok := p.types[name]; ok && !p.defaultTypes[name] {
+		return fmt.Errorf("%s is already registered", name)
+	}
+	if _, ok := p.completers[name]; ok && t.Completer != nil && !p.defaultComps[name] {
+		return fmt.Errorf("%s is already registered", name)
+	}
+	delete(p.defaultTypes, name)
+	p.types[name] = t
+	if t.Completer != nil {
+		delete(p.defaultComps, name)
+		p.completers[name] = t.Completer.withContext().described()
+	} else if p.defaultComps[name] {
+		// the replaced type's completer goes with it
+		delete(p.defaultComps, name)
+		delete(p.completers, name)
+	}
+	return nil
+}
+
+// PopCommandSet removes the latest command set pushed, calling its OnExit
+// function.
 func (p *Prompt) PopCommandSet() error {
 	if len(p.cmdSetStack) > 1 {
+		top := p.cmdSetStack[len(p.cmdSetStack)-1]
 		p.cmdSetStack = p.cmdSetStack[0 : len(p.cmdSetStack)-1]
+		if top.CommandSet.OnExit != nil {
+			top.CommandSet.OnExit(top.Context)
+		}
 		return nil
 	}
 	return errors.New("can't pop command set")
@@ -181,11 +380,26 @@ func (p *Prompt) PopCommandSet() error {
 
 // PushCommandSet enters a new command set and pushes it to the stack.
 func (p *Prompt) PushCommandSet(name string) error {
-	if cs, ok := p.commandSets[name]; ok {
-		p.cmdSetStack = append(p.cmdSetStack, cs)
-		return nil
+	return p.PushCommandSetContext(name, nil)
+}
+
+// PushCommandSetContext enters a new command set with a context value, e.g.
+// the interface name when entering an interface configuration mode.  The
+// context is available to commands via ModeContext while the command set is
+// current.  If the command set has an OnEnter function and it returns an
+// error, the command set is not entered.
+func (p *Prompt) PushCommandSetContext(name string, ctx interface{}) error {
+	cs, ok := p.commandSets[name]
+	if !ok {
+		return fmt.Errorf("unknown command set: %s", name)
 	}
-	return fmt.Errorf("unknown command set: %s", name)
+	if cs.OnEnter != nil {
+		if err := cs.OnEnter(ctx); err != nil {
+			return err
+		}
+	}
+	p.cmdSetStack = append(p.cmdSetStack, Mode{cs, ctx})
+	return nil
 }
 
 // CurrentCommandSet returns the current command set in use.
@@ -193,7 +407,46 @@ func (p *Prompt) CurrentCommandSet() *CommandSet {
 	if len(p.cmdSetStack) == 0 {
 		return nil
 	}
-	return p.cmdSetStack[len(p.cmdSetStack)-1]
+	return p.cmdSetStack[len(p.cmdSetStack)-1].CommandSet
+}
+
+// ModeContext returns the context the current command set was entered with.
+func (p *Prompt) ModeContext() interface{} {
+	if len(p.cmdSetStack) == 0 {
+		return nil
+	}
+	return p.cmdSetStack[len(p.cmdSetStack)-1].Context
+}
+
+// Modes returns a copy of the command set stack, outermost first.
+func (p *Prompt) Modes() []Mode {
+	return append([]Mode(nil), p.cmdSetStack...)
+}
+
+// ModePrompt is the default Prompter.  It builds the prompt from the
+// PromptFragment of each command set on the stack in the style of
+// networking equipment, e.g. "router(config-if-eth0)> " where "router" is the
+// fragment of the outermost command set and "config" and "if-eth0" are the
+// fragments of the nested command sets.  If no fragments are provided, the
+// prompt is "> ".
+func (p *Prompt) ModePrompt() string {
+	root := ""
+	nested := []string{}
+	for i, m := range p.cmdSetStack {
+		if m.CommandSet.PromptFragment == nil {
+			continue
+		}
+		frag := m.CommandSet.PromptFragment(m.Context)
+		if i == 0 {
+			root = frag
+		} else if frag != "" {
+			nested = append(nested, frag)
+		}
+	}
+	if len(nested) > 0 {
+		return fmt.Sprintf("%s(%s)> ", root, strings.Join(nested, "-"))
+	}
+	return root + "> "
 }
 
 // RegisterFilter registers a filter for use by the user.
@@ -202,38 +455,233 @@ func (p *Prompt) CurrentCommandSet() *CommandSet {
 // 'foo' is the filter name,  and []string{"arg1","arg2"} would be
 // passed to the filter.
 func (p *Prompt) RegisterFilter(name string, fn Filter) error {
-	if _, ok := p.filters[name]; ok {
-		return fmt.Errorf("filter %s is already registered", name)
+	return p.RegisterFilterDef(FilterDef{
+		Name: name,
+		Run: func(r io.Reader, w io.Writer, args *Args) {
+			fn(r, w, args.Strings())
+		},
+	})
+}
+
+// RegisterFilterDef registers a filter that accepts flags, which are parsed
+// out of its arguments before it's run.
+func (p *Prompt) RegisterFilterDef(def FilterDef) error {
+	if _, ok := p.filters[def.Name]; ok {
+		return fmt.Errorf("filter %s is already registered", def.Name)
 	}
-	p.filters[name] = fn
+	if err := validateFlags(def.Flags); err != nil {
+		return err
+	}
+	p.filters[def.Name] = def
 	return nil
 }
 
-func (p *Prompt) execMatch(inp input) *command {
-	if p.CurrentCommandSet() == nil {
-		return nil
+// execMatch returns the command that best matches the user input, and how
+// the input is bound to it.  The commands are compared word by word,
+// preferring exact keyword matches, then abbreviated keywords, then
+// placeholders and finally wildcards.  If multiple commands match equally
+// well, an *AmbiguousError is returned.
+func (p *Prompt) execMatch(inp input) (*command, *binding, error) {
+	// an invalid flag or repeated option is reported if no command matches
+	var flagErr, optErr error
+	// search outward from the current command set, so the commands of inner
+	// command sets take precedence
+	for _, cs := range p.commandSetsInScope() {
+		var resolved []string
+		if p.Abbreviate {
+			var err error
+			if resolved, err = resolveAbbreviations(inp, cs.commands); err != nil {
+				return nil, nil, err
+			}
+		}
+		var best []*command
+		var bindings []*binding
+		for _, cmd := range cs.commands {
+			words, kept, flags, err := parseFlags(cmd.flags, inp.words, p.types)
+			if err != nil {
+				flagErr = err
+				continue
+			}
+			m := &matcher{words: words, abbrev: p.Abbreviate}
+			for _, i := range kept {
+				if i < len(resolved) {
+					m.resolved = append(m.resolved, resolved[i])
+				}
+			}
+			b := cmd.bind(m)
+			if b == nil {
+				m.repeats = true
+				if rb := cmd.bind(m); rb != nil {
+					optErr = cmd.repeatedOption(rb)
+				}
+				continue
+			}
+			b.flags = flags
+			if best == nil {
+				best, bindings = []*command{cmd}, []*binding{b}
+				continue
+			}
+			switch c := compareScores(b.scores, bindings[0].scores); {
+			case c > 0:
+				best, bindings = []*command{cmd}, []*binding{b}
+			case c == 0:
+				best = append(best, cmd)
+				bindings = append(bindings, b)
+			}
+		}
+		if len(best) == 1 {
+			return best[0], bindings[0], nil
+		}
+		if len(best) > 1 {
+			return nil, nil, newAmbiguousError(inp, best, bindings)
+		}
+	}
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+	if optErr != nil {
+		return nil, nil, optErr
+	}
+	return nil, nil, ErrCommandNotFound
+}
+
+// resolveAbbreviations returns the keyword each word of the input abbreviates
+// given the words before it.  A word that exactly matches a keyword is never
+// treated as an abbreviation of a longer one.  If a word abbreviates several
+// keywords, an *AmbiguousError is returned.
+func resolveAbbreviations(inp input, cmds []*command) ([]string, error) {
+	resolved := make([]string, len(inp.words))
+	for i, w := range inp.words {
+		m := &matcher{words: inp.words[:i], abbrev: true, resolved: resolved[:i]}
+		exact := false
+		keywords := []string{}
+		seen := map[string]bool{}
+		for _, cmd := range cmds {
+			next, _ := cmd.nextSegments(m)
+			for _, di := range next {
+				for _, kw := range cmd.desc.words[di].keywords() {
+					if kw == w.value {
+						exact = true
+					} else if strings.HasPrefix(kw, w.value) && !seen[kw] {
+						seen[kw] = true
+						keywords = append(keywords, kw)
+					}
+				}
+			}
+		}
+
+		switch {
+		case exact:
+			resolved[i] = w.value
+		case len(keywords) == 1:
+			resolved[i] = keywords[0]
+		case len(keywords) > 1:
+			return nil, &AmbiguousError{w.value, keywords}
+		}
+	}
+	return resolved, nil
+}
+
+// commandSetsInScope returns the current command set, its parents and
+// finally the built-in commands.
+func (p *Prompt) commandSetsInScope() []*CommandSet {
+	sets := []*CommandSet{}
+	if p.CurrentCommandSet() != nil {
+		sets = p.CurrentCommandSet().lineage()
+	}
+	return append(sets, p.builtins)
+}
+
+// availableCommands returns the commands of the current command set, its
+// parents and the built-in commands.
+func (p *Prompt) availableCommands() []*command {
+	cmds := []*command{}
+	for _, cs := range p.commandSetsInScope() {
+		cmds = append(cmds, cs.commands...)
+	}
+	return cmds
+}
+
+// tabCompleter completes line for a line reader that completes whole lines.
+func (p *Prompt) tabCompleter(line string) []string {
+	head, words := p.tabComplete(line)
+	lines := []string{}
+	for _, w := range words {
+		lines = append(lines, head+w)
 	}
-	var cmdMatch *command
-	var cmdMatchScore matchType
-	for _, cmd := range p.CurrentCommandSet().commands {
-		if mt := cmd.execMatch(inp); mt != matchNone && mt > cmdMatchScore {
-			cmdMatch = cmd
-			cmdMatchScore = mt
+	return lines
+}
+
+// wordCompleter completes the word at the cursor, pos runes into line, for a
+// line reader that completes words.  The text after the cursor is kept,
+// apart from the rest of the word being completed.
+func (p *Prompt) wordCompleter(line string, pos int) (string, []string, string) {
+	runes := []rune(line)
+	left, tail := string(runes[:pos]), string(runes[pos:])
+	if pos > 0 && isWord(runes[pos-1]) {
+		end := strings.IndexFunc(tail, func(r rune) bool { return !isWord(r) })
+		if end < 0 {
+			end = len(tail)
 		}
+		tail = tail[end:]
 	}
-	return cmdMatch
+	head, words := p.tabComplete(left)
+	return head, words, tail
 }
 
-func (p *Prompt) inputCompleter(line string) []string {
-	l, err := parseUserInput(line)
+// tabComplete returns the completions of the last word of line for the line
+// reader, and the text before the word.  Several completions with
+// descriptions are listed with them, aligned in columns, and the word is
+// completed as far as the completions agree.
+func (p *Prompt) tabComplete(line string) (string, []string) {
+	head, completions := p.completeLine(line)
+	words := completionTexts(completions)
+	if len(completions) < 2 || !hasDescriptions(completions) {
+		return head, words
+	}
+	p.listCompletions(line, completions)
+	current := ""
+	if strings.HasPrefix(line, head) {
+		current = line[len(head):]
+	}
+	if prefix := commonPrefix(words); len(prefix) > len(current) {
+		return head, []string{prefix}
+	}
+	return head, []string{current}
+}
+
+// completeLine returns the completions of the last word of line, sorted and
+// without duplicates, and the text before it.
+func (p *Prompt) completeLine(line string) (string, []Completion) {
+	comp := &completion{
+		ctx:        context.Background(),
+		mode:       p.ModeContext(),
+		completers: p.completers,
+		options:    p.completerOpts,
+		cache:      p.completions,
+		types:      p.types,
+	}
+	// complete within an open quote as if it were closed
+	start, open := lastWord(line)
+	closed := line
+	if open != 0 {
+		closed += string(open)
+	}
+	l, err := parseUserInput(closed)
+	if err != nil {
+		l = nil
+	}
+	if head, completions, ok := p.completeOutput(line, l, start, comp); ok {
+		return head, quoteCompletions(completions, open)
+	}
 	if err != nil {
 		// TODO: notify user of an error
-		return nil
+		return "", nil
 	}
 
 	type cmatch struct {
 		mt          completionType
-		completions []string
+		completions []Completion
 	}
 	// complete command words
 	cMatches := []cmatch{}
@@ -241,26 +689,42 @@ func (p *Prompt) inputCompleter(line string) []string {
 	if len(l) > 0 {
 		lastInput = l[len(l)-1].words
 	}
+	// the user has finished the last word, so complete the next one
+	if len(lastInput) > 0 && start == len(line) && strings.HasSuffix(line, " ") {
+		lastInput = append(lastInput, segment{})
+	}
 
 	hasPartialMatches := false
 	hasExactMatches := false
-	for _, cmd := range p.CurrentCommandSet().commands {
-		mt, completions := cmd.complete(lastInput, p.completers)
-		if mt == completeExact {
-			hasExactMatches = true
-		}
-		if mt == completePartial {
-			hasPartialMatches = true
-		}
+	var argErr error
+	for _, cs := range p.commandSetsInScope() {
+		comp.keywordHelp = cs.keywordHelp
+		for _, cmd := range cs.commands {
+			// don't complete past an invalid argument
+			if err := p.checkPrefix(cmd, lastInput); err != nil {
+				argErr = err
+				continue
+			}
+			mt, completions := cmd.complete(lastInput, comp)
+			if mt == completeExact {
+				hasExactMatches = true
+			}
+			if mt == completePartial {
+				hasPartialMatches = true
+			}
 
-		if mt != completeNone {
-			cMatches = append(cMatches, cmatch{mt, completions})
+			if mt != completeNone {
+				cMatches = append(cMatches, cmatch{mt, completions})
+			}
 		}
 	}
 
 	// no completion matches
 	if len(cMatches) == 0 {
-		return nil
+		if argErr != nil {
+			p.completionError(line, argErr)
+		}
+		return "", nil
 	}
 
 	// partial match is completing a term the user is currently typing: fo -> foo
@@ -278,41 +742,268 @@ func (p *Prompt) inputCompleter(line string) []string {
 		hasExactMatches = false
 	}
 
-	cWords := []string{}
-	// form our list of completions
+	// remove empty and duplicate completions, keeping the first description
+	byText := map[string]*Completion{}
+	texts := []string{}
 	for _, cm := range cMatches {
 		if cm.mt == completeNone {
 			continue
 		}
-		cWords = append(cWords, cm.completions...)
+		for i, c := range cm.completions {
+			if c.Text == "" {
+				continue
+			}
+			if prev, ok := byText[c.Text]; ok {
+				if prev.Description == "" {
+					prev.Description = c.Description
+				}
+				continue
+			}
+			byText[c.Text] = &cm.completions[i]
+			texts = append(texts, c.Text)
+		}
+	}
+	sort.Strings(texts)
+	completions := []Completion{}
+	for _, t := range texts {
+		completions = append(completions, *byText[t])
 	}
 
-	// remove completion duplicates by sorting and matching against
-	// the previous word
-	sort.Strings(cWords)
-	pw := ""
-
-	// we are matching the next word, so we leave the user's input alone and add
-	// a new segment to fill in with completions
+	// we are matching the next word, so we leave the user's input alone and
+	// the completions follow it
 	if hasExactMatches {
-		lastInput = append(lastInput, segment{})
+		if line != "" && !strings.HasSuffix(line, " ") {
+			line = closed + " "
+		}
+		return line, quoteCompletions(completions, 0)
 	}
+	// the completions replace the last word
+	return line[:start], quoteCompletions(completions, open)
+}
 
-	ret := []string{}
-	for _, cw := range cWords {
-		// empty or duplicate?
-		if len(cw) == 0 || cw == pw {
-			continue
+// lastWord returns the index in line of the start of its last word, which is
+// len(line) if line ends with a separator, and the quote the word leaves
+// open, if any.
+func lastWord(line string) (int, rune) {
+	start := 0
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && (quote == 0 || quote == '"'):
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case isQuote(r):
+			quote = r
+		case isSpace(r) || r == ';' || r == '|' || r == '>':
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	return start, quote
+}
+
+// quoteCompletions quotes the text of the completions that contain spaces or
+// other special characters, or all of them with open if it isn't zero.
+func quoteCompletions(completions []Completion, open rune) []Completion {
+	for i := range completions {
+		completions[i].Text = quoteWord(completions[i].Text, open)
+	}
+	return completions
+}
+
+// quoteWord returns word quoted with quote if it isn't zero, or with double
+// quotes if it contains characters that would otherwise split it.
+func quoteWord(word string, quote rune) string {
+	if quote == 0 {
+		if !strings.ContainsAny(word, " \t;|>$\\\"'`") {
+			return word
+		}
+		quote = '"'
+	}
+	if quote != '"' && !strings.ContainsRune(word, quote) {
+		return string(quote) + word + string(quote)
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(word) + `"`
+}
+
+// completeOutput completes the filter or output file name at the end of line,
+// which is parsed as l and whose last word starts at start, returning false
+// if line doesn't end with one.
+func (p *Prompt) completeOutput(line string, l []input, start int, comp *completion) (string, []Completion, bool) {
+	trimmed := strings.TrimRight(line, " \t")
+	head := line[:start]
+	if start == len(line) && !strings.HasSuffix(head, " ") {
+		head += " "
+	}
+	// a filter name follows the pipe
+	if strings.HasSuffix(trimmed, "|") {
+		return head, p.completeFilterNames(""), true
+	}
+	if len(l) == 0 || strings.HasSuffix(trimmed, ";") {
+		return "", nil, false
+	}
+	last := l[len(l)-1]
+	// the word being completed, or empty if it's the next word
+	word := ""
+	if start < len(line) {
+		word = last.outputFile
+		if word == "" && len(last.filters) > 0 {
+			f := last.filters[len(last.filters)-1]
+			word = f.cmd
+			if len(f.args) > 0 {
+				word = f.args[len(f.args)-1]
+			}
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(trimmed, ">"):
+		return head, plainCompletions(CompleteFile("")), true
+	case last.outputFile != "":
+		if word == "" {
+			// the file name is complete
+			return head, nil, true
+		}
+		return head, plainCompletions(CompleteFile(word)), true
+	case len(last.filters) > 0:
+		f := last.filters[len(last.filters)-1]
+		if word == "" {
+			return head, p.completeFilterArg(f.cmd, f.args, "", comp), true
+		}
+		if len(f.args) == 0 {
+			return head, p.completeFilterNames(word), true
+		}
+		return head, p.completeFilterArg(f.cmd, f.args[:len(f.args)-1], word, comp), true
+	}
+	return "", nil, false
+}
+
+// completeFilterNames returns the names of the filters that start with
+// prefix.
+func (p *Prompt) completeFilterNames(prefix string) []Completion {
+	names := []string{}
+	for name := range p.filters {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	completions := []Completion{}
+	for _, name := range names {
+		completions = append(completions, Completion{name, p.filters[name].Help})
+	}
+	return completions
+}
+
+// completeFilterArg returns the completions of prefix as the argument of a
+// filter following args.
+func (p *Prompt) completeFilterArg(name string, args []string, prefix string, comp *completion) []Completion {
+	def, ok := p.filters[name]
+	if !ok {
+		return nil
+	}
+	words := []segment{}
+	for _, a := range args {
+		words = append(words, segment{value: a, typ: wordType})
+	}
+	_, _, pending, err := scanFlags(def.Flags, words, nil)
+	if err != nil {
+		return nil
+	}
+	cc := &CompletionContext{Context: comp.ctx, Command: name, Arg: "$*", Args: &Args{}, Mode: comp.mode}
+	switch {
+	case pending != nil:
+		// complete the flag's value
+		cc.Arg = "--" + pending.Name
+		return comp.complete(cc, pending.Type, prefix, pending.Help)
+	case strings.HasPrefix(prefix, "-") && len(def.Flags) > 0:
+		if words := completeFlags(def.Flags, prefix); !(len(words) == 1 && words[0].Text == prefix) {
+			return words
 		}
-		pw = cw
+		return nil
+	case def.Completer == nil:
+		return nil
+	}
+	if a, err := def.filterArgs(args, comp.types); err == nil {
+		cc.Args = a
+	}
+	return plainCompletions(def.Completer(cc, prefix))
+}
+
+// hasDescriptions returns true if any of the completions has a description.
+func hasDescriptions(completions []Completion) bool {
+	for _, c := range completions {
+		if c.Description != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPrefix returns the longest prefix shared by all of the words, which
+// is never part of a rune.
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// listCompletions shows the completions with their descriptions below line,
+// and redraws the prompt and line below them.
+func (p *Prompt) listCompletions(line string, completions []Completion) {
+	b := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(b, 0, 8, 2, ' ', 0)
+	for _, c := range completions {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.Text, c.Description)
+	}
+	tw.Flush()
+	if w := p.terminal(); w != nil {
+		fmt.Fprintf(w, "\r\n%s%s%s", strings.Replace(b.String(), "\n", "\r\n", -1), p.curPrompt, line)
+	}
+}
 
-		// replace the last segment of the user input with our completion
-		lastInput[len(lastInput)-1].typ = wordType
-		lastInput[len(lastInput)-1].value = cw
-		// and add the completion to our list
-		ret = append(ret, asUser(lastInput))
+// checkPrefix validates the arguments in the words before the one being
+// completed.
+func (p *Prompt) checkPrefix(cmd *command, words []segment) error {
+	if len(words) < 2 {
+		return nil
+	}
+	words = cmd.withoutFlags(words[:len(words)-1])
+	b := cmd.bindPrefix(&matcher{words: words, abbrev: p.Abbreviate})
+	if b == nil {
+		return nil
 	}
-	return ret
+	return cmd.checkArgs(words, b, p.types)
+}
+
+// completionError shows an error found while completing line, and redraws
+// the prompt and line below it.
+func (p *Prompt) completionError(line string, err error) {
+	if w := p.terminal(); w != nil {
+		fmt.Fprintf(w, "\r\n%s\r\n%s%s", err, p.curPrompt, line)
+	}
+}
+
+// terminal returns the writer the line reader draws the prompt and input line
+// on, or nil if it doesn't draw them.
+func (p *Prompt) terminal() io.Writer {
+	if t, ok := p.LineReader.(terminalWriter); ok {
+		return t.Terminal()
+	}
+	return nil
 }
 
 func asUser(inp []segment) string {
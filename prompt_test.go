@@ -1,12 +1,20 @@
 package prompt
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/peterh/liner"
 )
 
 func TestCommandSetStack(t *testing.T) {
@@ -72,7 +80,7 @@ func TestPromptCompleter(t *testing.T) {
 		{"test", []string{"testing"}},
 		{"testb", nil}}
 	for _, tc := range tests {
-		got := p.inputCompleter(tc.input)
+		got := p.tabCompleter(tc.input)
 		if len(got) != len(tc.exp) {
 			t.Fatalf("expected %v, got %v", tc.exp, got)
 		}
@@ -84,6 +92,380 @@ func TestPromptCompleter(t *testing.T) {
 	}
 }
 
+// listedCompletions returns the completions listed on the terminal, and
+// resets it.
+func listedCompletions(term *bytes.Buffer) []string {
+	listed := []string{}
+	for _, line := range strings.Split(term.String(), "\r\n") {
+		if strings.HasPrefix(line, "  ") {
+			listed = append(listed, strings.SplitN(line[2:], "  ", 2)[0])
+		}
+	}
+	term.Reset()
+	return listed
+}
+
+func TestPromptContextCompleter(t *testing.T) {
+	term := &bytes.Buffer{}
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), term))
+	defer p.Close()
+
+	vlans := map[string][]string{"sw1": {"10", "20"}, "sw2": {"30"}}
+	p.RegisterCompleter("switch", completeWords("sw1", "sw2"))
+	var last *CompletionContext
+	p.RegisterContextCompleter("vlan", func(cc *CompletionContext, arg string) []string {
+		last = cc
+		return vlans[cc.Args.Get("sw")]
+	})
+	if err := p.RegisterContextCompleter("switch", nil); err == nil {
+		t.Errorf("expected an error registering a completer twice")
+	}
+
+	root := p.NewCommandSet("root")
+	root.RegisterArgsCommandFunc("show vlan $sw:switch $id:vlan", func(io.Writer, *Args) error { return nil })
+	p.PushCommandSetContext("root", "admin")
+
+	// several described completions are listed, and the word completed as
+	// far as they agree
+	tests := []struct {
+		input  string
+		exp    []string
+		listed []string
+	}{{"show vlan s", []string{"show vlan sw"}, []string{"sw1", "sw2"}},
+		{"show vlan sw1 ", []string{"show vlan sw1 "}, []string{"10", "20"}},
+		{"show vlan sw2 ", []string{"show vlan sw2 30"}, nil},
+		{"show vlan sw3 ", nil, nil}}
+	for _, tc := range tests {
+		got := p.tabCompleter(tc.input)
+		if strings.Join(got, ",") != strings.Join(tc.exp, ",") {
+			t.Errorf("expected %v, got %v for %q", tc.exp, got, tc.input)
+		}
+		if listed := listedCompletions(term); strings.Join(listed, ",") != strings.Join(tc.listed, ",") {
+			t.Errorf("expected %v listed, got %v for %q", tc.listed, listed, tc.input)
+		}
+	}
+
+	if last == nil {
+		t.Fatalf("expected the context completer to be called")
+	}
+	if last.Command != "show vlan $sw:switch $id:vlan" || last.Arg != "$id" || last.Mode != "admin" || last.Context == nil {
+		t.Errorf("unexpected completion context %+v", last)
+	}
+}
+
+func TestPromptCompletionDescriptions(t *testing.T) {
+	term := &bytes.Buffer{}
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), term))
+	defer p.Close()
+	stderr := &bytes.Buffer{}
+	p.Stderr = stderr
+
+	p.RegisterDescribedCompleter("user", func(cc *CompletionContext, arg string) []Completion {
+		return []Completion{{"alice", "admin"}, {"bob", ""}}
+	})
+	p.RegisterCompleter("group", completeWords("wheel", "staff"))
+	cs := p.NewCommandSet("root")
+	cs.RegisterCommand(CommandDef{Desc: "show users", Summary: "list users", Run: func(io.Writer, []string) error { return nil }})
+	cs.RegisterCommand(CommandDef{Desc: "show uptime", Summary: "show the uptime", Run: func(io.Writer, []string) error { return nil }})
+	cs.RegisterCommand(CommandDef{Desc: "user $name:user", Run: func(io.Writer, []string) error { return nil }})
+	cs.RegisterCommand(CommandDef{Desc: "group $1:group", ArgHelp: map[string]string{"$1": "the group name"}, Run: func(io.Writer, []string) error { return nil }})
+	p.PushCommandSet("root")
+
+	tests := []struct {
+		input string
+		exp   []Completion
+	}{
+		{"show u", []Completion{{"uptime", "show the uptime"}, {"users", "list users"}}},
+		{"user ", []Completion{{"alice", "admin"}, {"bob", "<name>"}}},
+		{"group ", []Completion{{"staff", "the group name"}, {"wheel", "the group name"}}},
+	}
+	for _, tc := range tests {
+		_, got := p.completeLine(tc.input)
+		if fmt.Sprint(got) != fmt.Sprint(tc.exp) {
+			t.Errorf("expected %v, got %v for %q", tc.exp, got, tc.input)
+		}
+	}
+
+	// the descriptions are listed, and the line completed as far as possible
+	if got := p.tabCompleter("show u"); len(got) != 1 || got[0] != "show u" {
+		t.Errorf("expected the line to be unchanged, got %v", got)
+	}
+	if exp := "\r\n  uptime  show the uptime\r\n  users   list users\r\nshow u"; term.String() != exp {
+		t.Errorf("expected %q, got %q", exp, term.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected nothing written to stderr, got %q", stderr.String())
+	}
+	if got := p.tabCompleter("show up"); len(got) != 1 || got[0] != "show uptime" {
+		t.Errorf("expected show uptime, got %v", got)
+	}
+	term.Reset()
+	if got := p.tabCompleter("group "); len(got) != 1 || got[0] != "group " {
+		t.Errorf("expected the line to be unchanged, got %v", got)
+	}
+}
+
+func TestPromptCompleterTimeout(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	// returns the hosts found when its context is done
+	p.RegisterContextCompleter("host", func(cc *CompletionContext, arg string) []string {
+		<-cc.Context.Done()
+		return []string{"a1"}
+	})
+	// never notices its context is done
+	release := make(chan struct{})
+	defer close(release)
+	p.RegisterCompleter("stuck", func(string) []string {
+		<-release
+		return []string{"b1"}
+	})
+	cs := p.NewCommandSet("root")
+	cs.RegisterCommandFunc("ping $1:host", func(io.Writer, []string) {})
+	cs.RegisterCommandFunc("trace $1:stuck", func(io.Writer, []string) {})
+	p.PushCommandSet("root")
+
+	if err := p.SetCompleterOptions("missing", CompleterOptions{}); err == nil {
+		t.Errorf("expected an error setting the options of an unregistered completer")
+	}
+	p.SetCompleterOptions("host", CompleterOptions{Timeout: 10 * time.Millisecond})
+	p.SetCompleterOptions("stuck", CompleterOptions{Timeout: 10 * time.Millisecond})
+
+	if got := p.tabCompleter("ping "); len(got) != 1 || got[0] != "ping a1" {
+		t.Errorf("expected the partial completions, got %v", got)
+	}
+	start := time.Now()
+	if got := p.tabCompleter("trace "); len(got) != 0 {
+		t.Errorf("expected no completions, got %v", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected completion to time out, took %s", elapsed)
+	}
+}
+
+func TestPromptCompleterCache(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	calls := 0
+	p.RegisterCompleter("host", func(arg string) []string {
+		calls++
+		return []string{arg + "1"}
+	})
+	cs := p.NewCommandSet("root")
+	cs.RegisterCommandFunc("ping $1:host", func(io.Writer, []string) {})
+	cs.RegisterCommandFunc("trace $1 $2:host", func(io.Writer, []string) {})
+	p.PushCommandSet("root")
+	p.SetCompleterOptions("host", CompleterOptions{CacheTTL: time.Hour})
+
+	tests := []struct {
+		input string
+		exp   string
+		calls int
+	}{
+		{"ping a", "ping a1", 1},
+		{"ping a", "ping a1", 1},
+		{"ping b", "ping b1", 2},
+		{"trace x a", "trace x a1", 3},
+		{"trace y a", "trace y a1", 4},
+		{"trace x a", "trace x a1", 4},
+	}
+	for _, tc := range tests {
+		if got := p.tabCompleter(tc.input); len(got) != 1 || got[0] != tc.exp {
+			t.Errorf("expected %s, got %v for %q", tc.exp, got, tc.input)
+		}
+		if calls != tc.calls {
+			t.Errorf("expected %d calls, got %d for %q", tc.calls, calls, tc.input)
+		}
+	}
+
+	p.SetCompleterOptions("host", CompleterOptions{CacheTTL: time.Millisecond})
+	p.tabCompleter("ping c")
+	time.Sleep(5 * time.Millisecond)
+	p.tabCompleter("ping c")
+	if calls != 6 {
+		t.Errorf("expected expired completions to be refreshed, got %d calls", calls)
+	}
+}
+
+func TestPromptCompleterCacheModes(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	calls := 0
+	vlans := map[string]string{"sw1": "10", "sw2": "20"}
+	p.RegisterContextCompleter("vlan", func(cc *CompletionContext, arg string) []string {
+		calls++
+		if sw, ok := cc.Mode.(string); ok {
+			return []string{vlans[sw]}
+		}
+		return []string{"30"}
+	})
+	p.NewCommandSet("root")
+	cs := p.NewCommandSet("switch")
+	cs.RegisterCommandFunc("vlan $1:vlan", func(io.Writer, []string) {})
+	p.SetCompleterOptions("vlan", CompleterOptions{CacheTTL: time.Hour})
+
+	tests := []struct {
+		mode  interface{}
+		exp   string
+		calls int
+	}{
+		{"sw1", "vlan 10", 1},
+		{"sw2", "vlan 20", 2},
+		{"sw1", "vlan 10", 2},
+		// a context that can't be compared isn't cached
+		{[]string{"sw3"}, "vlan 30", 3},
+		{[]string{"sw3"}, "vlan 30", 4},
+	}
+	for _, tc := range tests {
+		p.PushCommandSetContext("switch", tc.mode)
+		if got := p.tabCompleter("vlan "); len(got) != 1 || got[0] != tc.exp {
+			t.Errorf("expected %s, got %v in %v", tc.exp, got, tc.mode)
+		}
+		if calls != tc.calls {
+			t.Errorf("expected %d calls, got %d in %v", tc.calls, calls, tc.mode)
+		}
+		p.PopCommandSet()
+	}
+}
+
+func TestPromptCompleteOutput(t *testing.T) {
+	term := &bytes.Buffer{}
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), term))
+	defer p.Close()
+
+	dir, err := ioutil.TempDir("", "prompt")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"log.txt", "lines.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("unable to create file: %s", err)
+		}
+	}
+
+	p.RegisterFilterDef(GrepFilter)
+	p.RegisterFilter("head", func(io.Reader, io.Writer, []string) {})
+	p.RegisterType("level", ArgType{Completer: completeWords("debug", "info")})
+	p.RegisterFilterDef(FilterDef{
+		Name:  "level",
+		Flags: []Flag{{Name: "min", Type: "level"}},
+		Completer: func(cc *CompletionContext, arg string) []string {
+			return []string{fmt.Sprintf("%s%d", arg, len(cc.Args.Strings()))}
+		},
+		Run: func(io.Reader, io.Writer, *Args) {},
+	})
+	cs := p.NewCommandSet("root")
+	cs.RegisterCommandFunc("show log", func(io.Writer, []string) {})
+	p.PushCommandSet("root")
+
+	tests := []struct {
+		input  string
+		exp    []string
+		listed []string
+	}{
+		{"show log | ", []string{"show log | "}, []string{"grep", "head", "level"}},
+		{"show log |", []string{"show log | "}, []string{"grep", "head", "level"}},
+		{"show log | g", []string{"show log | grep"}, nil},
+		{"show log | grep -", []string{"show log | grep -"}, []string{"--ignore-case", "-i", "--invert-match", "-v"}},
+		{"show log | grep x ", nil, nil},
+		{"show log | level --min ", []string{"show log | level --min debug", "show log | level --min info"}, nil},
+		{"show log | level --min d", []string{"show log | level --min debug"}, nil},
+		{"show log | level a b", []string{"show log | level a b1"}, nil},
+		{"show log | grep x | h", []string{"show log | grep x | head"}, nil},
+		{"show log > " + dir + "/l", []string{"show log > " + dir + "/lines.txt", "show log > " + dir + "/log.txt"}, nil},
+		{"show log >" + dir + "/lo", []string{"show log >" + dir + "/log.txt"}, nil},
+		{"show log > " + dir + "/log.txt ", nil, nil},
+	}
+	for _, tc := range tests {
+		if got := p.tabCompleter(tc.input); strings.Join(got, ",") != strings.Join(tc.exp, ",") {
+			t.Errorf("expected %v, got %v for %q", tc.exp, got, tc.input)
+		}
+		if listed := listedCompletions(term); strings.Join(listed, ",") != strings.Join(tc.listed, ",") {
+			t.Errorf("expected %v listed, got %v for %q", tc.listed, listed, tc.input)
+		}
+	}
+
+	if _, got := p.completeLine("show log | g"); len(got) != 1 || got[0].Description != GrepFilter.Help {
+		t.Errorf("expected the filter to be described, got %v", got)
+	}
+}
+
+func TestPromptWordCompleter(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	cs := p.NewCommandSet("root")
+	cs.RegisterCommandFunc("show interface $1", func(io.Writer, []string) {})
+	cs.RegisterCommandFunc("show version", func(io.Writer, []string) {})
+	cs.RegisterCommandFunc("clear counters", func(io.Writer, []string) {})
+	p.PushCommandSet("root")
+
+	tests := []struct {
+		line  string
+		pos   int
+		head  string
+		words []string
+		tail  string
+	}{
+		{"sh interface eth0", 2, "", []string{"show"}, " interface eth0"},
+		{"show int eth0", 8, "show ", []string{"interface"}, " eth0"},
+		{"show inface eth0", 6, "show ", []string{"interface"}, " eth0"},
+		{"show v", 6, "show ", []string{"version"}, ""},
+		{"clear counters; show  v | grep x", 23, "clear counters; show  ", []string{"version"}, " | grep x"},
+		{"show  eth0", 5, "show ", []string{"interface", "version"}, " eth0"},
+	}
+	for _, tc := range tests {
+		head, words, tail := p.wordCompleter(tc.line, tc.pos)
+		if head != tc.head || strings.Join(words, ",") != strings.Join(tc.words, ",") || tail != tc.tail {
+			t.Errorf("expected (%q, %v, %q), got (%q, %v, %q) for %q at %d", tc.head, tc.words, tc.tail, head, words, tail, tc.line, tc.pos)
+		}
+	}
+}
+
+func TestPromptQuoting(t *testing.T) {
+	term := &bytes.Buffer{}
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), term))
+	defer p.Close()
+
+	p.RegisterCompleter("file", completeWords("my file.txt", "my notes", "readme", `say "hi"`))
+	cs := p.NewCommandSet("root")
+	cs.RegisterCommandFunc("echo $*", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "%q", args)
+	})
+	cs.RegisterCommandFunc("cat $1:file", func(io.Writer, []string) {})
+	p.PushCommandSet("root")
+
+	out, err := p.Execute(context.Background(), `echo "hello world" it\'s`)
+	if err != nil || out != `["hello world" "it's"]` {
+		t.Errorf("expected the quotes to be removed, got %s (%v)", out, err)
+	}
+
+	tests := []struct {
+		input string
+		exp   []string
+	}{
+		{"cat m", []string{`cat "my `}},
+		{"cat r", []string{"cat readme"}},
+		{`cat "my f`, []string{`cat "my file.txt"`}},
+		{`cat 'my n`, []string{`cat 'my notes'`}},
+		{`cat 'r`, []string{`cat 'readme'`}},
+		{`cat s`, []string{`cat "say \"hi\""`}},
+		{`echo "a b" ; cat "my f`, []string{`echo "a b" ; cat "my file.txt"`}},
+	}
+	for _, tc := range tests {
+		if got := p.tabCompleter(tc.input); strings.Join(got, ",") != strings.Join(tc.exp, ",") {
+			t.Errorf("expected %v, got %v for %q", tc.exp, got, tc.input)
+		}
+	}
+	if got := listedCompletions(term); strings.Join(got, ",") != `"my file.txt","my notes"` {
+		t.Errorf("expected the quoted files to be listed, got %v", got)
+	}
+}
+
 func buildTestPrompt(t *testing.T) (prompt *Prompt, cleanup func()) {
 	oldStdin := os.Stdin
 	oldStdout := os.Stdout
@@ -100,6 +482,7 @@ func buildTestPrompt(t *testing.T) (prompt *Prompt, cleanup func()) {
 	os.Stdout = repStdout
 
 	p := NewPrompt()
+	p.Stderr = repStdout
 	return p, func() {
 		os.Stdin = oldStdin
 		os.Stdout = oldStdout
@@ -181,14 +564,19 @@ func TestPromptSingleCommandWildcard(t *testing.T) {
 	p, cleanup := buildTestPrompt(t)
 	defer cleanup()
 
-	cmdArgs := []string{}
+	calls := [][]string{}
 	cs := p.NewCommandSet("foo")
 	cs.RegisterCommandFunc("test $*", func(w io.Writer, args []string) {
-		cmdArgs = args
+		calls = append(calls, args)
+	})
+	cs.RegisterCommandFunc("copy $+", func(w io.Writer, args []string) {
+		calls = append(calls, append([]string{"copy"}, args...))
 	})
 
 	fmt.Fprintf(os.Stdin, "test bar baz\n")
 	fmt.Fprintf(os.Stdin, "test\n")
+	fmt.Fprintf(os.Stdin, "copy\n")
+	fmt.Fprintf(os.Stdin, "copy a\n")
 	_, err := os.Stdin.Seek(0, 0)
 
 	if err != nil {
@@ -198,11 +586,14 @@ func TestPromptSingleCommandWildcard(t *testing.T) {
 	for p.Prompt() {
 	}
 
-	if len(cmdArgs) != 2 {
-		t.Fatalf("expected command to run")
+	exp := [][]string{{"bar", "baz"}, {}, {"copy", "a"}}
+	if len(calls) != len(exp) {
+		t.Fatalf("expected %d commands to run, got %v", len(exp), calls)
 	}
-	if cmdArgs[0] != "bar" || cmdArgs[1] != "baz" {
-		t.Errorf("expected args = bar, baz, got %v", cmdArgs)
+	for i := range exp {
+		if strings.Join(calls[i], " ") != strings.Join(exp[i], " ") {
+			t.Errorf("expected args = %v, got %v", exp[i], calls[i])
+		}
 	}
 }
 
@@ -242,3 +633,463 @@ func TestPromptFuzz(t *testing.T) {
 	}
 	// no tests, just insuring garbage input won't crash
 }
+
+func TestPromptOutputWriters(t *testing.T) {
+	lr := NewReaderLineReader(strings.NewReader("test\ntest | grep b\nmissing\ntest | nofilter\n"), nil)
+	p := NewPromptWithReader(lr)
+	defer p.Close()
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	p.Stdout = stdout
+	p.Stderr = stderr
+	p.RegisterFilter("grep", Grep)
+
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("test", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "a\nb\n")
+	})
+
+	for p.Prompt() {
+	}
+	if exp := "a\nb\nb\n"; stdout.String() != exp {
+		t.Errorf("expected stdout %q, got %q", exp, stdout.String())
+	}
+	if exp := "missing: command not found\ntest | nofilter: nofilter is not a valid filter\n"; stderr.String() != exp {
+		t.Errorf("expected stderr %q, got %q", exp, stderr.String())
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		words []string
+		exp   string
+	}{
+		{nil, ""},
+		{[]string{"show"}, "show"},
+		{[]string{"show", "shutdown"}, "sh"},
+		{[]string{"héllo", "hèllo"}, "h"},
+		{[]string{"日本", "日付"}, "日"},
+		{[]string{"a", "b"}, ""},
+	}
+	for _, tc := range tests {
+		if got := commonPrefix(tc.words); got != tc.exp {
+			t.Errorf("expected %q, got %q for %q", tc.exp, got, tc.words)
+		}
+	}
+}
+
+// historyReader is a ReaderLineReader that records its history.
+type historyReader struct {
+	*ReaderLineReader
+	history []string
+}
+
+func (h *historyReader) AppendHistory(item string) {
+	h.history = append(h.history, item)
+}
+
+func TestPromptContinuation(t *testing.T) {
+	prompts := &bytes.Buffer{}
+	input := "echo a \\\nb\necho \"c\nd\" e\nset {\n a 1\n}\necho f \\\n"
+	lr := &historyReader{ReaderLineReader: NewReaderLineReader(strings.NewReader(input), prompts)}
+	p := NewPromptWithReader(lr)
+	defer p.Close()
+	p.Prompter = func() string { return "$ " }
+	p.ContinuePrompt = "... "
+	errs := []error{}
+	p.OnError = func(w io.Writer, err error) {
+		errs = append(errs, err)
+	}
+
+	calls := [][]string{}
+	cs := p.NewCommandSet("root")
+	cs.RegisterCommandFunc("echo $*", func(w io.Writer, args []string) {
+		calls = append(calls, args)
+	})
+	cs.RegisterCommandFunc("set $*", func(w io.Writer, args []string) {
+		calls = append(calls, args)
+	})
+
+	for p.Prompt() {
+	}
+	if exp := `[["a" "b"] ["c\nd" "e"] ["{" "a" "1" "}"]]`; fmt.Sprintf("%q", calls) != exp {
+		t.Errorf("expected calls %s, got %q", exp, calls)
+	}
+	if exp := "$ ... $ ... $ ... ... $ ... "; prompts.String() != exp {
+		t.Errorf("expected prompts %q, got %q", exp, prompts.String())
+	}
+	exp := []string{"echo a b", "echo \"c d\" e", "set {  a 1 }"}
+	if fmt.Sprintf("%q", lr.history) != fmt.Sprintf("%q", exp) {
+		t.Errorf("expected history %q, got %q", exp, lr.history)
+	}
+	// each entry is a line of the saved history
+	saved := &bytes.Buffer{}
+	w := liner.NewLiner()
+	for _, h := range lr.history {
+		w.AppendHistory(h)
+	}
+	w.WriteHistory(saved)
+	w.Close()
+	r := liner.NewLiner()
+	defer r.Close()
+	if n, err := r.ReadHistory(bytes.NewReader(saved.Bytes())); n != len(exp) || err != nil {
+		t.Errorf("expected %d history entries read, got %d (%v)", len(exp), n, err)
+	}
+	reloaded := &bytes.Buffer{}
+	if r.WriteHistory(reloaded); reloaded.String() != saved.String() {
+		t.Errorf("expected history %q, got %q", saved.String(), reloaded.String())
+	}
+	// the input ends part way through the last command
+	if len(errs) != 1 || errs[0].Error() != "echo f \\: unterminated command" {
+		t.Errorf("expected an unterminated command, got %v", errs)
+	}
+}
+
+func TestHistoryEntry(t *testing.T) {
+	tests := []struct {
+		input string
+		exp   string
+	}{
+		{"echo a", "echo a"},
+		{"echo a \\\n  b", "echo a   b"},
+		{"echo a\\\nb", "echo a b"},
+		{"echo 'a \\\nb'", "echo 'a \\ b'"},
+		{"echo \"a\n\nb\"", "echo \"a  b\""},
+		{"set {\n\ta \\\n\t1\n}", "set { \ta \t1 }"},
+	}
+	for _, tc := range tests {
+		if got := historyEntry(tc.input); got != tc.exp {
+			t.Errorf("expected %q, got %q for %q", tc.exp, got, tc.input)
+		}
+	}
+}
+
+func TestPromptErrCommand(t *testing.T) {
+	lr := NewReaderLineReader(strings.NewReader("fail a; ok\nok\nfail b\n"), nil)
+	p := NewPromptWithReader(lr)
+	defer p.Close()
+	errs := []error{}
+	p.OnError = func(w io.Writer, err error) {
+		errs = append(errs, err)
+	}
+
+	ran := 0
+	cs := p.NewCommandSet("foo")
+	cs.RegisterErrCommandFunc("fail $1", func(w io.Writer, args []string) error {
+		return fmt.Errorf("failed %s", args[0])
+	})
+	cs.RegisterCommandFunc("ok", func(w io.Writer, args []string) {
+		ran++
+	})
+
+	// the first line fails before running ok
+	if !p.Prompt() || p.LastError() == nil {
+		t.Fatalf("expected an error")
+	}
+	if ran != 0 {
+		t.Errorf("expected command after failure not to run")
+	}
+	if !p.Prompt() || p.LastError() != nil {
+		t.Errorf("expected no error, got %s", p.LastError())
+	}
+	if !p.Prompt() || p.LastError() == nil {
+		t.Fatalf("expected an error")
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected two errors, got %v", errs)
+	}
+	ce, ok := errs[1].(*CommandError)
+	if !ok {
+		t.Fatalf("expected a *CommandError, got %T", errs[1])
+	}
+	if ce.Line != "fail b" || ce.Err.Error() != "failed b" {
+		t.Errorf("unexpected error %s", ce)
+	}
+}
+
+func TestPromptExecute(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+	p.RegisterFilter("grep", Grep)
+
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("echo $*", func(w io.Writer, args []string) {
+		fmt.Fprintln(w, strings.Join(args, " "))
+	})
+	cs.RegisterErrCommandFunc("fail", func(w io.Writer, args []string) error {
+		return errors.New("failed")
+	})
+
+	tests := []struct {
+		line string
+		out  string
+		err  string
+	}{
+		{"echo a b", "a b\n", ""},
+		{"echo a; echo b", "a\nb\n", ""},
+		{"echo a; echo b | grep b", "a\nb\n", ""},
+		{"echo a | grep b", "", ""},
+		{"echo a; fail; echo b", "a\n", "fail: failed"},
+		{"missing", "", "missing: command not found"},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.out {
+			t.Errorf("expected output %q, got %q for %s", tc.out, out, tc.line)
+		}
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v for %s", tc.err, err, tc.line)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.Execute(ctx, "echo a"); err != context.Canceled {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+}
+
+func TestCommandSetParent(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	root := p.NewCommandSet("root")
+	root.RegisterCommandFunc("exit", func(w io.Writer, args []string) {
+		fmt.Fprint(w, "root exit")
+	})
+	root.RegisterCommandFunc("show", func(w io.Writer, args []string) {
+		fmt.Fprint(w, "root show")
+	})
+	config := p.NewCommandSet("config")
+	config.RegisterCommandFunc("exit", func(w io.Writer, args []string) {
+		fmt.Fprint(w, "config exit")
+	})
+	if err := config.SetParent(root); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if err := root.SetParent(config); err == nil {
+		t.Errorf("expected an error creating a cycle")
+	}
+	p.PushCommandSet("config")
+
+	for _, tc := range []struct{ line, exp string }{
+		{"exit", "config exit"},
+		{"show", "root show"},
+	} {
+		if out, err := p.Execute(context.Background(), tc.line); err != nil || out != tc.exp {
+			t.Errorf("expected %q, got %q (%v)", tc.exp, out, err)
+		}
+	}
+	if got := p.tabCompleter("s"); len(got) != 1 || got[0] != "show" {
+		t.Errorf("expected parent command completion, got %v", got)
+	}
+}
+
+func TestCommandSetContext(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	root := p.NewCommandSet("root")
+	root.RegisterErrCommandFunc("interface $1", PushCommandSetContext(p, "interface"))
+	iface := p.NewCommandSet("interface")
+	iface.RegisterCommandFunc("exit", PopCommandSet(p))
+	iface.RegisterCommandFunc("show", func(w io.Writer, args []string) {
+		fmt.Fprint(w, p.ModeContext())
+	})
+
+	events := []string{}
+	iface.OnEnter = func(ctx interface{}) error {
+		if ctx == "bad0" {
+			return errors.New("no such interface")
+		}
+		events = append(events, fmt.Sprintf("enter %v", ctx))
+		return nil
+	}
+	iface.OnExit = func(ctx interface{}) {
+		events = append(events, fmt.Sprintf("exit %v", ctx))
+	}
+
+	if _, err := p.Execute(context.Background(), "interface bad0"); err == nil {
+		t.Errorf("expected an error entering the command set")
+	}
+	if p.CurrentCommandSet() != root {
+		t.Errorf("expected command set not to be entered")
+	}
+	out, err := p.Execute(context.Background(), "interface eth0; show; exit")
+	if err != nil || out != "eth0" {
+		t.Errorf("expected eth0, got %q (%v)", out, err)
+	}
+	if p.ModeContext() != nil {
+		t.Errorf("expected no context, got %v", p.ModeContext())
+	}
+	if len(events) != 2 || events[0] != "enter eth0" || events[1] != "exit eth0" {
+		t.Errorf("unexpected enter/exit events %v", events)
+	}
+}
+
+func TestModePrompt(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	root := p.NewCommandSet("root")
+	config := p.NewCommandSet("config")
+	iface := p.NewCommandSet("interface")
+	if got := p.Prompter(); got != "> " {
+		t.Errorf("expected default prompt, got %q", got)
+	}
+
+	root.PromptFragment = func(interface{}) string { return "router" }
+	config.PromptFragment = func(interface{}) string { return "config" }
+	iface.PromptFragment = func(ctx interface{}) string { return fmt.Sprintf("if-%v", ctx) }
+	tests := []struct {
+		push string
+		ctx  interface{}
+		exp  string
+	}{
+		{"", nil, "router> "},
+		{"config", nil, "router(config)> "},
+		{"interface", "eth0", "router(config-if-eth0)> "},
+	}
+	for _, tc := range tests {
+		if tc.push != "" {
+			p.PushCommandSetContext(tc.push, tc.ctx)
+		}
+		if got := p.Prompter(); got != tc.exp {
+			t.Errorf("expected prompt %q, got %q", tc.exp, got)
+		}
+	}
+
+	modes := p.Modes()
+	if len(modes) != 3 || modes[0].CommandSet != root || modes[2].CommandSet.Name() != "interface" || modes[2].Context != "eth0" {
+		t.Errorf("unexpected modes %v", modes)
+	}
+}
+
+func TestPromptAbbreviate(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	cs := p.NewCommandSet("foo")
+	for _, desc := range []string{"show ip interface brief", "show version", "clear", "set $1", "exit", "ex"} {
+		desc := desc
+		cs.RegisterCommandFunc(desc, func(w io.Writer, args []string) {
+			fmt.Fprint(w, desc)
+		})
+	}
+
+	tests := []struct {
+		abbrev bool
+		line   string
+		exp    string
+		err    string
+	}{
+		{false, "sh ip int br", "", "sh ip int br: command not found"},
+		{false, "show version", "show version", ""},
+		{true, "sh ip int br", "show ip interface brief", ""},
+		{true, "sh v", "show version", ""},
+		{true, "cl", "clear", ""},
+		{true, "se foo", "set $1", ""},
+		{true, "ex", "ex", ""},
+		{true, "exi", "exit", ""},
+		{true, "s v", "", `s v: ambiguous command "s", could be: show, set`},
+		{true, "sh", "", "sh: command not found"},
+		{true, "e", "", `e: ambiguous command "e", could be: exit, ex`},
+		{true, "shx", "", "shx: command not found"},
+	}
+	for _, tc := range tests {
+		p.Abbreviate = tc.abbrev
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.exp || (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected %q (%s), got %q (%v)", tc.exp, tc.err, out, err)
+		}
+	}
+}
+
+func TestPromptOptional(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("show [ip] route [$1]", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "%q", args)
+	})
+	cs.RegisterCommandFunc("show interface [$1] [detail]", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "%q", args)
+	})
+	cs.RegisterCommandFunc("logging {on|off|debug}", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "%q", args)
+	})
+
+	tests := []struct {
+		abbrev bool
+		line   string
+		exp    string
+	}{
+		{false, "show route", `["" ""]`},
+		{false, "show route 10.0.0.0", `["" "10.0.0.0"]`},
+		{false, "show ip route 10.0.0.0", `["ip" "10.0.0.0"]`},
+		{false, "show interface", `["" ""]`},
+		{false, "show interface eth0 detail", `["eth0" "detail"]`},
+		{false, "show interface detail", `["" "detail"]`},
+		{true, "sh int eth0 det", `["eth0" "detail"]`},
+		{true, "sh ip r", `["ip" ""]`},
+		{true, "sh i r", ""},
+		{false, "logging off", `["off"]`},
+		{true, "log deb", `["debug"]`},
+		{false, "logging of", ""},
+	}
+	for _, tc := range tests {
+		p.Abbreviate = tc.abbrev
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.exp || (err == nil) != (tc.exp != "") {
+			t.Errorf("expected %s, got %s (%v) for %s", tc.exp, out, err, tc.line)
+		}
+	}
+}
+
+func TestPromptAmbiguous(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+	cs := p.NewCommandSet("foo")
+
+	// registration prevents this, so build the commands directly
+	for _, desc := range []string{"show $1", "show $2"} {
+		cmd, err := parseCommand(desc, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		cs.commands = append(cs.commands, cmd)
+	}
+	_, err := p.Execute(context.Background(), "show foo")
+	if ce, ok := err.(*CommandError); !ok {
+		t.Errorf("expected a *CommandError, got %v", err)
+	} else if ae, ok := ce.Err.(*AmbiguousError); !ok || len(ae.Candidates) != 2 {
+		t.Errorf("expected an ambiguous command error, got %v", ce.Err)
+	}
+}
+
+func TestPromptKeywordPriority(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	defer p.Close()
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("d $1 y z", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "late %v", args)
+	})
+	cs.RegisterCommandFunc("d x $1 $2", func(w io.Writer, args []string) {
+		fmt.Fprintf(w, "early %v", args)
+	})
+
+	tests := []struct {
+		input string
+		exp   string
+	}{
+		{"d x y z", "early [y z]"},
+		{"d w y z", "late [w]"},
+		{"d x a b", "early [a b]"},
+	}
+	for _, tc := range tests {
+		if out, err := p.Execute(context.Background(), tc.input); out != tc.exp || err != nil {
+			t.Errorf("expected %q for %s, got %q (%v)", tc.exp, tc.input, out, err)
+		}
+	}
+}
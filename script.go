@@ -0,0 +1,98 @@
+package prompt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ScriptOptions controls how a script is run by RunScript.
+type ScriptOptions struct {
+	Echo        bool // write each line, preceded by the prompt, before running it
+	StopOnError bool // stop running at the first line that fails
+}
+
+// ScriptError is the error reported when a line of a script fails.
+type ScriptError struct {
+	Line int   // the line number, starting at 1
+	Err  error // the underlying error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// RunScript runs the commands read from r, one line at a time, writing their
+// output to Stdout.  Blank lines and lines starting with '#' are ignored.  As
+// at the prompt, a command continues on the next line if it's incomplete,
+// e.g. the line ends with a backslash.
+// Failures are reported by the error handler and the first one is returned
+// as a *ScriptError.
+func (p *Prompt) RunScript(ctx context.Context, r io.Reader, opts ScriptOptions) error {
+	err := p.runScript(ctx, r, opts, p.Stdout, p.reportError)
+	if err == nil {
+		p.lastErr = nil
+	}
+	return err
+}
+
+// runScript runs the commands in r, writing output to out and passing each
+// failure to report if it's not nil.
+func (p *Prompt) runScript(ctx context.Context, r io.Reader, opts ScriptOptions, out io.Writer, report func(error)) error {
+	var firstErr error
+	// fail records the failure of the command starting at lineNo, returning
+	// true if the script should stop
+	fail := func(lineNo int, err error) bool {
+		err = &ScriptError{lineNo, err}
+		if report != nil {
+			report(err)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		return opts.StopOnError
+	}
+
+	sc := bufio.NewScanner(r)
+	lineNo, start := 0, 0
+	cmd := ""
+	var state lineState
+	for sc.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lineNo++
+		line := sc.Text()
+		if state.incomplete() {
+			cmd += "\n" + line
+			if opts.Echo {
+				fmt.Fprintf(out, "%s%s\n", p.ContinuePrompt, line)
+			}
+		} else {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			start, cmd = lineNo, line
+			if opts.Echo {
+				fmt.Fprintf(out, "%s%s\n", p.Prompter(), line)
+			}
+		}
+		if state = state.next(line); state.incomplete() {
+			continue
+		}
+
+		if err := p.runLine(ctx, cmd, out); err != nil && fail(start, err) {
+			return firstErr
+		}
+	}
+	if state.incomplete() {
+		fail(start, ErrUnterminatedCommand)
+	}
+	if err := sc.Err(); err != nil && firstErr == nil {
+		return err
+	}
+	return firstErr
+}
@@ -0,0 +1,169 @@
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func buildScriptPrompt() (*Prompt, *bytes.Buffer, *[]error) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	out := &bytes.Buffer{}
+	errs := &[]error{}
+	p.Stdout = out
+	p.OnError = func(w io.Writer, err error) {
+		*errs = append(*errs, err)
+	}
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("echo $*", func(w io.Writer, args []string) {
+		fmt.Fprintln(w, strings.Join(args, " "))
+	})
+	cs.RegisterArgsCommandFunc("source $1:file", Source(p))
+	return p, out, errs
+}
+
+func TestRunScript(t *testing.T) {
+	script := "echo a\n\n# comment\nbad\necho b\nbad again\n"
+	tests := []struct {
+		opts ScriptOptions
+		out  string
+		errs int
+	}{
+		{ScriptOptions{}, "a\nb\n", 2},
+		{ScriptOptions{StopOnError: true}, "a\n", 1},
+		{ScriptOptions{Echo: true, StopOnError: true}, "> echo a\na\n> bad\n", 1},
+	}
+	for _, tc := range tests {
+		p, out, errs := buildScriptPrompt()
+		err := p.RunScript(context.Background(), strings.NewReader(script), tc.opts)
+		if se, ok := err.(*ScriptError); !ok || se.Line != 4 {
+			t.Errorf("expected a failure on line 4, got %v", err)
+		}
+		if out.String() != tc.out {
+			t.Errorf("expected output %q, got %q", tc.out, out.String())
+		}
+		if len(*errs) != tc.errs {
+			t.Errorf("expected %d errors reported, got %v", tc.errs, *errs)
+		}
+		if p.LastError() == nil {
+			t.Errorf("expected last error to be set")
+		}
+	}
+}
+
+func TestRunScriptContinuation(t *testing.T) {
+	script := "echo a \\\n  b\n# c \\\n\necho \"d\n\ne\"\necho f {\n}\necho g \\\n"
+	p, out, errs := buildScriptPrompt()
+	p.ContinuePrompt = "... "
+	err := p.RunScript(context.Background(), strings.NewReader(script), ScriptOptions{Echo: true})
+	exp := "> echo a \\\n...   b\na b\n> echo \"d\n... \n... e\"\nd\n\ne\n> echo f {\n... }\nf { }\n> echo g \\\n"
+	if out.String() != exp {
+		t.Errorf("expected output %q, got %q", exp, out.String())
+	}
+	if se, ok := err.(*ScriptError); !ok || se.Line != 10 || se.Err != ErrUnterminatedCommand {
+		t.Errorf("expected an unterminated command on line 10, got %v", err)
+	}
+	if len(*errs) != 1 {
+		t.Errorf("expected 1 error reported, got %v", *errs)
+	}
+}
+
+func TestSource(t *testing.T) {
+	f, err := ioutil.TempFile("", "prompt")
+	if err != nil {
+		t.Fatalf("unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "echo a\nbad\necho b\n")
+	f.Close()
+
+	p, _, _ := buildScriptPrompt()
+	out, err := p.Execute(context.Background(), "source "+f.Name())
+	if out != "a\n" {
+		t.Errorf("expected output %q, got %q", "a\n", out)
+	}
+	if err == nil || !strings.HasSuffix(err.Error(), "line 2: bad: command not found") {
+		t.Errorf("expected failure on line 2, got %v", err)
+	}
+}
+
+func TestRunScriptCanceled(t *testing.T) {
+	p, out, errs := buildScriptPrompt()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.CurrentCommandSet().RegisterCommandFunc("cancel", func(io.Writer, []string) {
+		cancel()
+	})
+
+	err := p.RunScript(ctx, strings.NewReader("echo a\ncancel\necho b\necho c\n"), ScriptOptions{})
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if out.String() != "a\n" {
+		t.Errorf("expected output %q, got %q", "a\n", out.String())
+	}
+	if len(*errs) != 0 {
+		t.Errorf("expected no errors reported, got %v", *errs)
+	}
+}
+
+func TestSourceRecursion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prompt")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	ioutil.WriteFile(a, []byte("echo a\nsource "+b+"\n"), 0644)
+	ioutil.WriteFile(b, []byte("echo b\nsource "+a+"\n"), 0644)
+
+	p, _, _ := buildScriptPrompt()
+	out, err := p.Execute(context.Background(), "source "+a)
+	if out != "a\nb\n" {
+		t.Errorf("expected output %q, got %q", "a\nb\n", out)
+	}
+	if exp := ": " + a + " is already being sourced"; err == nil || !strings.HasSuffix(err.Error(), exp) {
+		t.Errorf("expected %q, got %v", exp, err)
+	}
+
+	// the files can be sourced again once they're done
+	if out, _ := p.Execute(context.Background(), "source "+b); out != "b\na\n" {
+		t.Errorf("expected output %q, got %q", "b\na\n", out)
+	}
+}
+
+func TestSourceContext(t *testing.T) {
+	f, err := ioutil.TempFile("", "prompt")
+	if err != nil {
+		t.Fatalf("unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "echo a\ncancel\necho b\n")
+	f.Close()
+
+	p, _, _ := buildScriptPrompt()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.CurrentCommandSet().RegisterCommandFunc("cancel", func(io.Writer, []string) {
+		cancel()
+	})
+	out, err := p.Execute(ctx, "source "+f.Name())
+	if out != "a\n" {
+		t.Errorf("expected output %q, got %q", "a\n", out)
+	}
+	if err == nil || !strings.HasSuffix(err.Error(), context.Canceled.Error()) {
+		t.Errorf("expected the source to be canceled, got %v", err)
+	}
+}
+
+func TestSourceNotBuiltin(t *testing.T) {
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), nil))
+	_, err := p.Execute(context.Background(), "source /etc/passwd")
+	if ce, ok := err.(*CommandError); !ok || ce.Err != ErrCommandNotFound {
+		t.Errorf("expected source to need registering, got %v", err)
+	}
+}
@@ -4,9 +4,9 @@ package prompt
 
 import "fmt"
 
-const _segmentType_name = "wordTypeplaceholderType"
+const _segmentType_name = "wordTypeplaceholderTypealternationType"
 
-var _segmentType_index = [...]uint8{0, 8, 23}
+var _segmentType_index = [...]uint8{0, 8, 23, 38}
 
 func (i segmentType) String() string {
 	if i >= segmentType(len(_segmentType_index)-1) {
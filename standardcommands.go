@@ -3,6 +3,8 @@ package prompt
 import (
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 )
 
 // PushCommandSet returns a command that enters a named command set.
@@ -25,9 +27,50 @@ func PushCommandSetArg(p *Prompt) Command {
 	}
 }
 
+// PushCommandSetContext returns a command that enters a named command set,
+// using the argument passed in as the context. It should be registered with a
+// description like "interface $1" to specify that it takes a single argument.
+func PushCommandSetContext(p *Prompt, name string) ErrCommand {
+	return func(w io.Writer, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("expected a single argument")
+		}
+		return p.PushCommandSetContext(name, args[0])
+	}
+}
+
 // PopCommandSet returns a command that enters a leaves the current command set.
 func PopCommandSet(p *Prompt) Command {
 	return func(w io.Writer, args []string) {
 		p.PopCommandSet()
 	}
 }
+
+// Source returns a command that runs the commands in the file passed in as an
+// argument, stopping at the first failure.  It should be registered with
+// RegisterArgsCommandFunc and a description like "source $1:file".  A file
+// can't source itself, directly or through other files.
+func Source(p *Prompt) ArgsCommand {
+	return func(w io.Writer, args *Args) error {
+		if len(args.Strings()) != 1 {
+			return fmt.Errorf("expected a single argument")
+		}
+		file := args.Strings()[0]
+		name, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		if p.sourcing[name] {
+			return fmt.Errorf("%s is already being sourced", file)
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		p.sourcing[name] = true
+		defer delete(p.sourcing, name)
+		return p.runScript(args.ctx, f, ScriptOptions{StopOnError: true}, w, nil)
+	}
+}
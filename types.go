@@ -0,0 +1,183 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validator checks a placeholder argument, returning it converted to the
+// value it represents or an error describing why it's invalid.
+type Validator func(arg string) (interface{}, error)
+
+// ArgType is a placeholder type, e.g. the "int" in "$1:int".  Arguments for
+// a placeholder with a registered type are validated before the command is
+// run.
+type ArgType struct {
+	Completer Completer // completes the argument, may be nil
+	Validator Validator // validates the argument, may be nil
+}
+
+// Pre-defined argument types, registered with every Prompt under the names
+// int, float, bool, duration, ip, cidr, mac, hostname and regexp.  Registering
+// a type or completer with one of these names replaces it.
+var (
+	// IntType is an integer, converted to an int.
+	IntType = ArgType{Validator: validateInt}
+	// FloatType is a floating point number, converted to a float64.
+	FloatType = ArgType{Validator: validateFloat}
+	// BoolType is a boolean, converted to a bool.
+	BoolType = ArgType{Completer: completeWords("true", "false"), Validator: validateBool}
+	// DurationType is a duration such as "1m30s", converted to a
+	// time.Duration.
+	DurationType = ArgType{Validator: validateDuration}
+	// IPType is an IPv4 or IPv6 address, converted to a net.IP.
+	IPType = ArgType{Validator: validateIP}
+	// CIDRType is an address and prefix length such as "10.0.0.0/8",
+	// converted to a *net.IPNet.
+	CIDRType = ArgType{Validator: validateCIDR}
+	// MACType is a hardware address, converted to a net.HardwareAddr.
+	MACType = ArgType{Validator: validateMAC}
+	// HostnameType is a host name, converted to a string.
+	HostnameType = ArgType{Validator: validateHostname}
+	// RegexpType is a regular expression, converted to a *regexp.Regexp.
+	RegexpType = ArgType{Validator: validateRegexp}
+)
+
+func builtinTypes() map[string]ArgType {
+	return map[string]ArgType{
+		"int":      IntType,
+		"float":    FloatType,
+		"bool":     BoolType,
+		"duration": DurationType,
+		"ip":       IPType,
+		"cidr":     CIDRType,
+		"mac":      MACType,
+		"hostname": HostnameType,
+		"regexp":   RegexpType,
+	}
+}
+
+// IntRange returns an argument type for integers between min and max
+// inclusive, converted to an int.
+func IntRange(min, max int) ArgType {
+	return ArgType{Validator: func(arg string) (interface{}, error) {
+		v, err := validateInt(arg)
+		if err != nil {
+			return nil, err
+		}
+		if n := v.(int); n < min || n > max {
+			return nil, fmt.Errorf("must be between %d and %d", min, max)
+		}
+		return v, nil
+	}}
+}
+
+func validateInt(arg string) (interface{}, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, errors.New("not an integer")
+	}
+	return n, nil
+}
+
+func validateFloat(arg string) (interface{}, error) {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return nil, errors.New("not a number")
+	}
+	return f, nil
+}
+
+func validateBool(arg string) (interface{}, error) {
+	b, err := strconv.ParseBool(arg)
+	if err != nil {
+		return nil, errors.New("not true or false")
+	}
+	return b, nil
+}
+
+func validateDuration(arg string) (interface{}, error) {
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		return nil, errors.New("not a duration, e.g. 1m30s")
+	}
+	return d, nil
+}
+
+func validateIP(arg string) (interface{}, error) {
+	ip := net.ParseIP(arg)
+	if ip == nil {
+		return nil, errors.New("not an IP address")
+	}
+	return ip, nil
+}
+
+func validateCIDR(arg string) (interface{}, error) {
+	_, n, err := net.ParseCIDR(arg)
+	if err != nil {
+		return nil, errors.New("not an address and prefix length, e.g. 10.0.0.0/8")
+	}
+	return n, nil
+}
+
+func validateMAC(arg string) (interface{}, error) {
+	mac, err := net.ParseMAC(arg)
+	if err != nil {
+		return nil, errors.New("not a MAC address")
+	}
+	return mac, nil
+}
+
+func validateHostname(arg string) (interface{}, error) {
+	name := strings.TrimSuffix(arg, ".")
+	if name == "" || len(name) > 253 {
+		return nil, errors.New("not a valid host name")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !isHostLabel(label) {
+			return nil, errors.New("not a valid host name")
+		}
+	}
+	return arg, nil
+}
+
+// isHostLabel reports whether s is a valid label of a host name.
+func isHostLabel(s string) bool {
+	if len(s) == 0 || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validateRegexp(arg string) (interface{}, error) {
+	re, err := regexp.Compile(arg)
+	if err != nil {
+		return nil, fmt.Errorf("not a regular expression: %s", strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+	}
+	return re, nil
+}
+
+// completeWords returns a completer for a fixed set of words.
+func completeWords(words ...string) Completer {
+	return func(arg string) []string {
+		matches := []string{}
+		for _, w := range words {
+			if strings.HasPrefix(w, arg) {
+				matches = append(matches, w)
+			}
+		}
+		return matches
+	}
+}
@@ -0,0 +1,147 @@
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestArgTypes(t *testing.T) {
+	tests := []struct {
+		typ   ArgType
+		arg   string
+		value string
+		err   string
+	}{
+		{IntType, "42", "42", ""},
+		{IntType, "4x", "", "not an integer"},
+		{IntRange(1, 4094), "4094", "4094", ""},
+		{IntRange(1, 4094), "0", "", "must be between 1 and 4094"},
+		{FloatType, "1.5", "1.5", ""},
+		{FloatType, "a", "", "not a number"},
+		{BoolType, "true", "true", ""},
+		{BoolType, "yes", "", "not true or false"},
+		{DurationType, "1m30s", "1m30s", ""},
+		{DurationType, "90", "", "not a duration, e.g. 1m30s"},
+		{IPType, "10.0.0.1", "10.0.0.1", ""},
+		{IPType, "fe80::1", "fe80::1", ""},
+		{IPType, "10.0.0", "", "not an IP address"},
+		{CIDRType, "10.1.0.0/16", "10.1.0.0/16", ""},
+		{CIDRType, "10.1.0.0", "", "not an address and prefix length, e.g. 10.0.0.0/8"},
+		{MACType, "00:11:22:aa:bb:cc", "00:11:22:aa:bb:cc", ""},
+		{MACType, "00:11:22", "", "not a MAC address"},
+		{HostnameType, "router-1.example.com.", "router-1.example.com.", ""},
+		{HostnameType, "-router", "", "not a valid host name"},
+		{HostnameType, "a..b", "", "not a valid host name"},
+		{RegexpType, "^eth[0-9]+$", "^eth[0-9]+$", ""},
+		{RegexpType, "eth[", "", "not a regular expression: missing closing ]: `[`"},
+	}
+	for _, tc := range tests {
+		v, err := tc.typ.Validator(tc.arg)
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v for %s", tc.err, err, tc.arg)
+		}
+		if err == nil && fmt.Sprint(v) != tc.value {
+			t.Errorf("expected %s, got %v", tc.value, v)
+		}
+	}
+}
+
+func TestPromptTypes(t *testing.T) {
+	term := &bytes.Buffer{}
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), term))
+	defer p.Close()
+	stderr := &bytes.Buffer{}
+	p.Stderr = stderr
+	if err := p.RegisterType("vlan", IntRange(1, 4094)); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if err := p.RegisterType("vlan", IntType); err == nil {
+		t.Errorf("expected an error registering a type twice")
+	}
+
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("vlan $1:vlan name $2", func(w io.Writer, args []string) {
+		fmt.Fprint(w, args)
+	})
+
+	tests := []struct {
+		line string
+		out  string
+		err  string
+	}{
+		{"vlan 10 name users", "[10 users]", ""},
+		{"vlan 5000 name users", "", `vlan 5000 name users: invalid <vlan> "5000": must be between 1 and 4094`},
+		{"vlan ten name users", "", `vlan ten name users: invalid <vlan> "ten": not an integer`},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.out || (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected %q (%s), got %q (%v)", tc.out, tc.err, out, err)
+		}
+	}
+
+	if got := p.tabCompleter("vlan 10 "); len(got) != 1 || got[0] != "vlan 10 name" {
+		t.Errorf("expected completion of name, got %v", got)
+	}
+	if got := p.tabCompleter("vlan 5000 "); len(got) != 0 {
+		t.Errorf("expected no completions, got %v", got)
+	}
+	if !strings.Contains(term.String(), `invalid <vlan> "5000": must be between 1 and 4094`) {
+		t.Errorf("expected the completion error to be shown, got %q", term.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected nothing written to stderr, got %q", stderr.String())
+	}
+}
+
+func TestPromptReplaceBuiltinTypes(t *testing.T) {
+	term := &bytes.Buffer{}
+	p := NewPromptWithReader(NewReaderLineReader(strings.NewReader(""), term))
+	defer p.Close()
+	if err := p.RegisterCompleter("bool", completeWords("yes", "no")); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if err := p.RegisterCompleter("bool", completeWords("on", "off")); err == nil {
+		t.Errorf("expected an error registering a completer twice")
+	}
+	if err := p.RegisterType("int", IntRange(1, 10)); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if err := p.RegisterType("int", IntType); err == nil {
+		t.Errorf("expected an error registering a type twice")
+	}
+	if err := p.RegisterType("ip", ArgType{}); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	cs := p.NewCommandSet("foo")
+	cs.RegisterCommandFunc("set $1:bool $2:int $3:ip", func(w io.Writer, args []string) {
+		fmt.Fprint(w, args)
+	})
+
+	tests := []struct {
+		line string
+		out  string
+		err  string
+	}{
+		{"set true 5 x", "[true 5 x]", ""},
+		{"set yes 5 x", "", `set yes 5 x: invalid <bool> "yes": not true or false`},
+		{"set true 50 x", "", `set true 50 x: invalid <int> "50": must be between 1 and 10`},
+	}
+	for _, tc := range tests {
+		out, err := p.Execute(context.Background(), tc.line)
+		if out != tc.out || (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("expected %q (%s), got %q (%v)", tc.out, tc.err, out, err)
+		}
+	}
+	if got := p.tabCompleter("set "); strings.Join(got, ",") != "set " {
+		t.Errorf("expected the line to be unchanged, got %v", got)
+	}
+	if got := listedCompletions(term); strings.Join(got, ",") != "no,yes" {
+		t.Errorf("expected the replaced completer, got %v", got)
+	}
+}